@@ -0,0 +1,174 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+)
+
+// ParameterAppender is implemented by types that can add the values of their
+// fields tagged with "parameter" to the query parameters on their own, without
+// reflection; QueryParametersFrom() uses it when available. The methods are
+// usually generated by the request-gen command (see cmd/request-gen), which
+// produces exactly the same values as the reflective path.
+type ParameterAppender interface {
+	AppendParameters(parameters url.Values) error
+}
+
+// HeaderAppender is implemented by types that can add the values of their
+// fields tagged with "header" to the headers on their own, without reflection;
+// HeadersFrom() uses it when available (see ParameterAppender). Header names
+// are added as they are, since they are canonicalized by the Builder.
+type HeaderAppender interface {
+	AppendHeaders(headers http.Header) error
+}
+
+// VariableAppender is implemented by types that can set the values of their
+// fields tagged with "variable" in the URL variables on their own, without
+// reflection; VariablesFrom() uses it when available (see ParameterAppender).
+// Values are URI template values, i.e. strings, lists of strings and maps of
+// strings, as VariablesFrom() would set them.
+type VariableAppender interface {
+	AppendVariables(variables map[string]interface{}) error
+}
+
+// appenderOf returns the method adding the values tagged with the given tag,
+// if the source implements the corresponding appender interface with a method
+// of its own; methods that may be promoted from an embedded field are ignored,
+// since they would only add the values of the embedded field.
+func appenderOf(tag string, source interface{}) (func(map[string][]string) error, bool) {
+	if v := reflect.ValueOf(source); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, false
+	}
+	switch tag {
+	case "parameter":
+		if appender, ok := source.(ParameterAppender); ok && !promoted(source, "AppendParameters") {
+			return func(values map[string][]string) error {
+				return appender.AppendParameters(values)
+			}, true
+		}
+	case "header":
+		if appender, ok := source.(HeaderAppender); ok && !promoted(source, "AppendHeaders") {
+			return func(values map[string][]string) error {
+				return appender.AppendHeaders(values)
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// variableAppenderOf returns the source as a VariableAppender, if it implements
+// the interface with a method of its own (see appenderOf()).
+func variableAppenderOf(source interface{}) (VariableAppender, bool) {
+	if v := reflect.ValueOf(source); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, false
+	}
+	appender, ok := source.(VariableAppender)
+	return appender, ok && !promoted(source, "AppendVariables")
+}
+
+// promoted returns whether the named method may be promoted from a field
+// embedded in the struct type of the source, that is if any embedded field has
+// a method by that name; since reflection cannot tell a promoted method from one
+// declared on the outer type that shadows it, both are reported, and the caller
+// falls back to the reflective path, which produces the same values anyway.
+func promoted(source interface{}, method string) bool {
+	typ := reflect.TypeOf(source)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		if _, ok := field.Type.MethodByName(method); ok {
+			return true
+		}
+		if field.Type.Kind() != reflect.Ptr && field.Type.Kind() != reflect.Interface {
+			if _, ok := reflect.PtrTo(field.Type).MethodByName(method); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AppendStruct adds the values of the fields of the source struct tagged with
+// the given key (e.g. "parameter") to values, exactly as QueryParametersFrom()
+// and friends would, using the appender interfaces if the source implements
+// them; values other than structs and non-nil pointers to structs are ignored.
+// It is meant to be called by generated code for untagged fields, which are
+// scanned recursively.
+func AppendStruct(values map[string][]string, key string, source interface{}) error {
+	if appender, ok := appenderOf(key, source); ok {
+		return appender(values)
+	}
+	s, ok := structValue(reflect.ValueOf(source))
+	if !ok {
+		return nil
+	}
+	scanned := map[string]map[string][]taggedValue{key: {}}
+	if err := scanStruct([]string{key}, s, scanned); err != nil {
+		return err
+	}
+	return marshalValuesInto(key, scanned[key], values)
+}
+
+// AppendField adds the value of a single struct field, given as a pointer to
+// the field, to values under the given name, exactly as QueryParametersFrom()
+// and friends would if the field had the given tag; name is the name in the
+// tag or, if it is empty, the name of the field. It is meant to be called by
+// generated code for fields whose types or tag options it does not handle.
+func AppendField(values map[string][]string, key string, name string, tag Tag, field interface{}) error {
+	v := reflect.ValueOf(field)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return &SourceError{Tag: key, Source: field}
+	}
+	scanned := map[string]map[string][]taggedValue{key: {}}
+	if err := scanField(fieldTag{key: key, name: name, tag: tag}, v.Elem(), scanned); err != nil {
+		return err
+	}
+	return marshalValuesInto(key, scanned[key], values)
+}
+
+// AppendVariableStruct is like AppendStruct(), but it sets the values of the
+// fields tagged with "variable" in variables as URI template values, exactly as
+// VariablesFrom() would, using the VariableAppender interface if the source
+// implements it.
+func AppendVariableStruct(variables map[string]interface{}, source interface{}) error {
+	if appender, ok := variableAppenderOf(source); ok {
+		return appender.AppendVariables(variables)
+	}
+	s, ok := structValue(reflect.ValueOf(source))
+	if !ok {
+		return nil
+	}
+	scanned := map[string]map[string][]taggedValue{"variable": {}}
+	if err := scanStruct([]string{"variable"}, s, scanned); err != nil {
+		return err
+	}
+	return templateValuesInto(scanned["variable"], variables)
+}
+
+// AppendVariableField is like AppendField(), but it sets the value of a single
+// field tagged with "variable" in variables as a URI template value, exactly as
+// VariablesFrom() would.
+func AppendVariableField(variables map[string]interface{}, name string, tag Tag, field interface{}) error {
+	v := reflect.ValueOf(field)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return &SourceError{Tag: "variable", Source: field}
+	}
+	scanned := map[string]map[string][]taggedValue{"variable": {}}
+	if err := scanField(fieldTag{key: "variable", name: name, tag: tag}, v.Elem(), scanned); err != nil {
+		return err
+	}
+	return templateValuesInto(scanned["variable"], variables)
+}
@@ -0,0 +1,155 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// GetValuesFromStruct exposes the reflective path to the tests of the code
+// generated by request-gen, which live in package request_test.
+var GetValuesFromStruct = getValuesFromStruct
+
+// GetVariablesFromStruct is like GetValuesFromStruct, for URL variables.
+var GetVariablesFromStruct = getVariablesFromStruct
+
+type fixedAppender struct {
+	Name string `parameter:"name" header:"X-Name"`
+}
+
+func (a fixedAppender) AppendParameters(parameters url.Values) error {
+	parameters["name"] = append(parameters["name"], "appended")
+	return nil
+}
+
+func (a *fixedAppender) AppendHeaders(headers http.Header) error {
+	return errors.New("cannot append headers")
+}
+
+func TestAppenders(t *testing.T) {
+	source := fixedAppender{Name: "reflected"}
+	if values, err := getValuesFrom("parameter", source); err != nil || !reflect.DeepEqual(values, map[string][]string{"name": {"appended"}}) {
+		t.Fatalf("expected appended parameters, got %v (error: %v)", values, err)
+	}
+	// value receivers do not implement the interface for pointer receivers
+	if values, err := getValuesFrom("header", source); err != nil || !reflect.DeepEqual(values, map[string][]string{"X-Name": {"reflected"}}) {
+		t.Fatalf("expected reflected headers, got %v (error: %v)", values, err)
+	}
+	if _, err := getValuesFrom("header", &source); err == nil || err.Error() != "cannot append headers" {
+		t.Fatalf("expected appender error, got %v", err)
+	}
+	// methods promoted from embedded structs are ignored, since they would only
+	// add the values of the embedded struct
+	outer := struct {
+		fixedAppender
+		Other string `parameter:"other" header:"X-Other"`
+	}{fixedAppender{Name: "reflected"}, "outer"}
+	for _, key := range []string{"parameter", "header"} {
+		for _, source := range []interface{}{outer, &outer} {
+			if _, ok := appenderOf(key, source); ok {
+				t.Fatalf("expected promoted %q appender of %T to be ignored", key, source)
+			}
+		}
+	}
+	if values, err := getValuesFrom("parameter", &outer); err != nil || !reflect.DeepEqual(values, map[string][]string{"name": {"reflected"}, "other": {"outer"}}) {
+		t.Fatalf("expected reflected parameters, got %v (error: %v)", values, err)
+	}
+	// nil pointers are invalid sources, even if their type has the methods
+	var sourceErr *SourceError
+	if _, err := getValuesFrom("parameter", (*fixedAppender)(nil)); !errors.As(err, &sourceErr) {
+		t.Fatalf("expected *SourceError, got %v", err)
+	}
+}
+
+func TestAppendStruct(t *testing.T) {
+	type paging struct {
+		Page  int `parameter:"page,omitempty"`
+		Limit int `parameter:"limit,default=20"`
+	}
+	values := map[string][]string{"page": {"1"}}
+	for _, source := range []interface{}{paging{Page: 2}, &paging{}, (*paging)(nil), "not a struct", &fixedAppender{}} {
+		if err := AppendStruct(values, "parameter", source); err != nil {
+			t.Fatalf("error appending %T: %v", source, err)
+		}
+	}
+	expected := map[string][]string{"page": {"1", "2"}, "limit": {"20", "20"}, "name": {"appended"}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("invalid values: expected %v, got %v", expected, values)
+	}
+}
+
+func TestAppendField(t *testing.T) {
+	var source struct {
+		Labels []string          `parameter:"label"`
+		Extra  map[string]string `parameter:",inline"`
+		Count  *int              `parameter:"count,required"`
+	}
+	source.Extra = map[string]string{"x": "1"}
+	values := map[string][]string{}
+	if err := AppendField(values, "parameter", "label", NewTag("label"), &source.Labels); err != nil {
+		t.Fatalf("error appending field: %v", err)
+	}
+	if err := AppendField(values, "parameter", "Extra", NewTag(",inline"), &source.Extra); err != nil {
+		t.Fatalf("error appending field: %v", err)
+	}
+	expected := map[string][]string{"label": {}, "x": {"1"}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("invalid values: expected %#v, got %#v", expected, values)
+	}
+	if err := AppendField(values, "parameter", "count", NewTag("count,required"), &source.Count); !errors.Is(err, ErrRequired) {
+		t.Fatalf("expected ErrRequired, got %v", err)
+	}
+	var sourceErr *SourceError
+	if err := AppendField(values, "parameter", "count", NewTag("count"), source.Count); !errors.As(err, &sourceErr) {
+		t.Fatalf("expected *SourceError, got %v", err)
+	}
+}
+
+func TestAppendVariables(t *testing.T) {
+	type paging struct {
+		Page int      `variable:"page,omitempty"`
+		Sort []string `variable:"sort"`
+	}
+	var source struct {
+		Path   []string       `variable:"path"`
+		Scopes []string       `variable:"scopes,comma"`
+		Filter map[string]int `variable:"filter"`
+		Count  *int           `variable:"count,required"`
+	}
+	source.Path = []string{"a", "b"}
+	source.Scopes = []string{"read", "write"}
+	source.Filter = map[string]int{"x": 1}
+	variables := map[string]interface{}{"page": "1"}
+	// empty lists leave the previous values in place
+	for _, s := range []interface{}{paging{Page: 2, Sort: []string{"name"}}, &paging{Sort: []string{}}, (*paging)(nil), "not a struct"} {
+		if err := AppendVariableStruct(variables, s); err != nil {
+			t.Fatalf("error appending %T: %v", s, err)
+		}
+	}
+	if err := AppendVariableField(variables, "path", NewTag("path"), &source.Path); err != nil {
+		t.Fatalf("error appending field: %v", err)
+	}
+	if err := AppendVariableField(variables, "scopes", NewTag("scopes,comma"), &source.Scopes); err != nil {
+		t.Fatalf("error appending field: %v", err)
+	}
+	if err := AppendVariableField(variables, "filter", NewTag("filter"), &source.Filter); err != nil {
+		t.Fatalf("error appending field: %v", err)
+	}
+	expected := map[string]interface{}{"page": "2", "sort": []string{"name"}, "path": []string{"a", "b"}, "scopes": "read,write", "filter": map[string]string{"x": "1"}}
+	if !reflect.DeepEqual(variables, expected) {
+		t.Fatalf("invalid variables: expected %#v, got %#v", expected, variables)
+	}
+	if err := AppendVariableField(variables, "count", NewTag("count,required"), &source.Count); !errors.Is(err, ErrRequired) {
+		t.Fatalf("expected ErrRequired, got %v", err)
+	}
+	var sourceErr *SourceError
+	if err := AppendVariableField(variables, "count", NewTag("count"), source.Count); !errors.As(err, &sourceErr) {
+		t.Fatalf("expected *SourceError, got %v", err)
+	}
+}
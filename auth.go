@@ -0,0 +1,65 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"time"
+)
+
+// Token is an authentication token, as set in the Authorization header.
+type Token struct {
+	// Type is the token type, "Bearer" if empty.
+	Type string
+	// Value is the token itself.
+	Value string
+	// Expiry is when the token expires; the zero value means it never does.
+	Expiry time.Time
+}
+
+// String returns the token as the value of an Authorization header.
+func (t *Token) String() string {
+	if t.Type == "" {
+		return "Bearer " + t.Value
+	}
+	return t.Type + " " + t.Value
+}
+
+// IsExpired returns whether the token has expired, or will expire within the
+// given leeway.
+func (t *Token) IsExpired(leeway time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(leeway).After(t.Expiry)
+}
+
+// TokenSource provides the tokens set in the Authorization header; it is
+// consulted each time a request is made, so implementations should cache
+// tokens and refresh them as needed.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc is an adapter to allow the use of ordinary functions as
+// TokenSources.
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+// Token calls f(ctx).
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a TokenSource that always returns the given bearer token.
+func StaticToken(value string) TokenSource {
+	token := &Token{Value: value}
+	return TokenSourceFunc(func(context.Context) (*Token, error) {
+		return token, nil
+	})
+}
+
+// Invalidator is implemented by TokenSources that cache tokens and can drop
+// them on demand; Builder.Do() invalidates the token when the server responds
+// with 401 (Unauthorized), so that a fresh one is obtained.
+type Invalidator interface {
+	Invalidate()
+}
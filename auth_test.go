@@ -0,0 +1,114 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBasicAuth(t *testing.T) {
+	req, err := New("https://www.example.com").BearerToken("token").BasicAuth("user", "pass").Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok || username != "user" || password != "pass" {
+		t.Fatalf("invalid basic authentication: got %q, %q", username, password)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	req, err := New("https://www.example.com").BearerToken("abcdef").Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("Authorization") != "Bearer abcdef" {
+		t.Fatalf("invalid bearer token: got %q", req.Header.Get("Authorization"))
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	req, err := New("https://www.example.com").
+		APIKeyHeader("X-API-Key", "key1").
+		APIKeyParameter("api_key", "key2").
+		Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("X-API-Key") != "key1" {
+		t.Fatalf("invalid API key header: got %q", req.Header.Get("X-API-Key"))
+	}
+	if req.URL.Query().Get("api_key") != "key2" {
+		t.Fatalf("invalid API key parameter: got %q", req.URL.Query().Get("api_key"))
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+	rotations := 0
+	source := TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		rotations++
+		return &Token{Type: "MAC", Value: fmt.Sprintf("token-%d", rotations)}, nil
+	})
+
+	parent := New("https://www.example.com").TokenSource(source)
+	for i, f := range []*Builder{parent, parent.New("", "/child")} {
+		req, err := f.Make()
+		if err != nil {
+			t.Fatalf("error making request: %v", err)
+		}
+		if expected := fmt.Sprintf("MAC token-%d", i+1); req.Header.Get("Authorization") != expected {
+			t.Fatalf("invalid rotated token: expected %q, got %q", expected, req.Header.Get("Authorization"))
+		}
+	}
+
+	failing := TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		return nil, errors.New("token endpoint unavailable")
+	})
+	var authErr *AuthError
+	if _, err := New("https://www.example.com").TokenSource(failing).Make(); !errors.As(err, &authErr) {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+	empty := TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		return nil, nil
+	})
+	if _, err := New("https://www.example.com").TokenSource(empty).Make(); !errors.As(err, &authErr) || !errors.Is(err, ErrNoToken) {
+		t.Fatalf("expected auth error wrapping ErrNoToken, got %v", err)
+	}
+
+	// printing a builder neither obtains tokens nor signs requests
+	calls := 0
+	f := New("https://www.example.com/{id}").
+		Set().Variable("id", 42).
+		TokenSource(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+			calls++
+			return &Token{Value: "token"}, nil
+		})).
+		Sign(SignerFunc(func(req *http.Request) error {
+			calls++
+			return nil
+		}))
+	if s := f.String(); calls != 0 || !strings.Contains(s, `"request": "https://www.example.com/42"`) {
+		t.Fatalf("invalid string (%d calls to token source and signers): %s", calls, s)
+	}
+}
+
+func TestTokenIsExpired(t *testing.T) {
+	if (&Token{}).IsExpired(time.Hour) {
+		t.Fatalf("tokens with no expiry should never expire")
+	}
+	token := &Token{Expiry: time.Now().Add(time.Minute)}
+	if token.IsExpired(0) {
+		t.Fatalf("token should not be expired yet")
+	}
+	if !token.IsExpired(2 * time.Minute) {
+		t.Fatalf("token should be expired within leeway")
+	}
+}
@@ -0,0 +1,645 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Command request-gen generates methods that add the values of struct fields
+// tagged with "parameter", "header" and "variable" to the query parameters, the
+// headers and the URL variables of a request without reflection; the methods
+// implement the ParameterAppender, HeaderAppender and VariableAppender
+// interfaces, which QueryParametersFrom(), HeadersFrom() and VariablesFrom()
+// use when available.
+//
+// Usage:
+//
+//	request-gen [-type T1,T2,...] [-output file] [file.go ...]
+//
+// It is meant to be run by go generate, e.g.:
+//
+//	//go:generate request-gen -type=ListItems
+//
+// If no files are given, all non-test Go files in the current directory are
+// parsed; if no types are given, methods are generated for all the structs
+// with tagged fields. The output file defaults to <type>_request.go, after the
+// first type.
+//
+// Fields of builtin types (strings, booleans, integers and floating point
+// numbers, pointers to and slices of them) are converted inline, honouring the
+// "default", "required", "omitempty" and delimiter tag options; fields of any
+// other type, or with the "format", "style", "explode" or "inline" options, are
+// converted by request.AppendField(), and untagged fields that may hold structs
+// are scanned by request.AppendStruct(), so that the values are always exactly
+// the same as those extracted via reflection; URL variables are set likewise
+// via request.AppendVariableField() and request.AppendVariableStruct(), which
+// also handle slices without delimiters, since they are set as lists.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	request "github.com/dihedron/go-request"
+)
+
+// header is the first line of generated files.
+const header = "// Code generated by request-gen. DO NOT EDIT."
+
+// target describes a method generated for one of the tags.
+type target struct {
+	// key is the tag key.
+	key string
+	// method is the name of the method.
+	method string
+	// param is the name of the parameter of the method.
+	param string
+	// typ is the type of the parameter of the method.
+	typ string
+	// iface is the interface implemented by the method.
+	iface string
+	// path is the import path of the package of the parameter type, if any.
+	path string
+	// assign is true if values replace the previous ones, rather than being
+	// added to them, as for URL variables, whose lists and maps are not
+	// converted inline.
+	assign bool
+}
+
+var targets = []target{
+	{key: "parameter", method: "AppendParameters", param: "parameters", typ: "url.Values", iface: "request.ParameterAppender", path: "net/url"},
+	{key: "header", method: "AppendHeaders", param: "headers", typ: "http.Header", iface: "request.HeaderAppender", path: "net/http"},
+	{key: "variable", method: "AppendVariables", param: "variables", typ: "map[string]interface{}", iface: "request.VariableAppender", assign: true},
+}
+
+// scalars maps the builtin types that are converted inline to their zero value.
+var scalars = map[string]string{
+	"string":  `""`,
+	"bool":    "false",
+	"int":     "0",
+	"int8":    "0",
+	"int16":   "0",
+	"int32":   "0",
+	"int64":   "0",
+	"rune":    "0",
+	"uint":    "0",
+	"uint8":   "0",
+	"uint16":  "0",
+	"uint32":  "0",
+	"uint64":  "0",
+	"uintptr": "0",
+	"byte":    "0",
+	"float32": "0",
+	"float64": "0",
+}
+
+// predeclared lists the predeclared types, which cannot hold structs.
+var predeclared = map[string]bool{
+	"any":        true,
+	"bool":       true,
+	"byte":       true,
+	"complex64":  true,
+	"complex128": true,
+	"error":      true,
+	"float32":    true,
+	"float64":    true,
+	"int":        true,
+	"int8":       true,
+	"int16":      true,
+	"int32":      true,
+	"int64":      true,
+	"rune":       true,
+	"string":     true,
+	"uint":       true,
+	"uint8":      true,
+	"uint16":     true,
+	"uint32":     true,
+	"uint64":     true,
+	"uintptr":    true,
+}
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; defaults to all structs with tagged fields")
+	output    = flag.String("output", "", "output file name; defaults to <type>_request.go")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("request-gen: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: request-gen [-type T1,T2,...] [-output file] [file.go ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		var err error
+		if files, err = packageFiles("."); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+	src, first, err := generate(files, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := *output
+	if name == "" {
+		name = filepath.Join(filepath.Dir(files[0]), strings.ToLower(first)+"_request.go")
+	}
+	if err := ioutil.WriteFile(name, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// packageFiles returns the non-test Go files in the directory, except those
+// generated by request-gen.
+func packageFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(data, []byte(header)) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return files, nil
+}
+
+// structType is a struct type declared in the parsed files.
+type structType struct {
+	name   string
+	fields []field
+}
+
+// field is a struct field; embedded fields are named after their type.
+type field struct {
+	name     string
+	typ      ast.Expr
+	tag      reflect.StructTag
+	pos      token.Position
+	embedded bool
+}
+
+// generate parses the files and returns the formatted source of the methods
+// for the given types (or for all structs with tagged fields), along with the
+// name of the first type.
+func generate(files []string, types []string) ([]byte, string, error) {
+	fset := token.NewFileSet()
+	var pkg string
+	var structs []*structType
+	named := map[string]*structType{}
+	others := map[string]bool{}
+	for _, name := range files {
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			return nil, "", err
+		}
+		if pkg == "" {
+			pkg = file.Name.Name
+		} else if pkg != file.Name.Name {
+			return nil, "", fmt.Errorf("files belong to different packages: %s and %s", pkg, file.Name.Name)
+		}
+		for _, decl := range file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				st, ok := spec.Type.(*ast.StructType)
+				if !ok {
+					others[spec.Name.Name] = true
+					continue
+				}
+				s := &structType{name: spec.Name.Name}
+				for _, f := range st.Fields.List {
+					var tag reflect.StructTag
+					if f.Tag != nil {
+						value, err := strconv.Unquote(f.Tag.Value)
+						if err != nil {
+							return nil, "", fmt.Errorf("%s: invalid tag: %v", fset.Position(f.Pos()), err)
+						}
+						tag = reflect.StructTag(value)
+					}
+					names := f.Names
+					if len(names) == 0 {
+						names = []*ast.Ident{ast.NewIdent(typeName(f.Type))}
+					}
+					for _, n := range names {
+						s.fields = append(s.fields, field{name: n.Name, typ: f.Type, tag: tag, pos: fset.Position(f.Pos()), embedded: len(f.Names) == 0})
+					}
+				}
+				structs = append(structs, s)
+				named[s.name] = s
+			}
+		}
+	}
+
+	var selected []*structType
+	if len(types) > 0 {
+		for _, name := range types {
+			s, ok := named[name]
+			if !ok {
+				return nil, "", fmt.Errorf("struct type %s not found", name)
+			}
+			selected = append(selected, s)
+		}
+	} else {
+		for _, s := range structs {
+			if s.isTagged() {
+				selected = append(selected, s)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, "", fmt.Errorf("no structs with tagged fields found")
+		}
+	}
+
+	g := &generator{others: others, imports: map[string]bool{}}
+	for _, s := range selected {
+		if err := g.generate(s); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%s\n\npackage %s\n\nimport (\n", header, pkg)
+	var imports []string
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	for _, path := range imports {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	fmt.Fprintf(&out, "\n\trequest %q\n)\n", "github.com/dihedron/go-request")
+	out.Write(g.buf.Bytes())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, "", fmt.Errorf("error formatting generated code: %v", err)
+	}
+	return src, selected[0].name, nil
+}
+
+// typeName returns the name of an embedded field given its type.
+func typeName(typ ast.Expr) string {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return typeName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.IndexExpr:
+		return typeName(t.X)
+	}
+	return ""
+}
+
+// isTagged returns whether any of the fields has any of the tags.
+func (s *structType) isTagged() bool {
+	for _, f := range s.fields {
+		for _, t := range targets {
+			if strings.TrimSpace(f.tag.Get(t.key)) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generator accumulates the generated methods.
+type generator struct {
+	buf bytes.Buffer
+	// others are the non-struct types declared in the parsed files.
+	others map[string]bool
+	// imports are the packages used by the generated code, besides request.
+	imports map[string]bool
+	// name is the name of the current type.
+	name string
+	// tags are the tags passed to request.AppendField() by the current type.
+	tags []string
+}
+
+// generate generates the methods for the struct type; a method is generated
+// for each tag that is used by a field, or that may be used by the structs held
+// by untagged fields, so that methods of embedded structs are never promoted in
+// place of the ones of the enclosing struct.
+func (g *generator) generate(s *structType) error {
+	g.name = s.name
+	g.tags = nil
+	var methods bytes.Buffer
+	for _, t := range targets {
+		var body bytes.Buffer
+		for _, f := range s.fields {
+			if !ast.IsExported(f.name) && !f.embedded {
+				// unexported fields are skipped
+				continue
+			}
+			if err := g.field(&body, t, f); err != nil {
+				return err
+			}
+		}
+		if body.Len() == 0 {
+			continue
+		}
+		if t.path != "" {
+			g.imports[t.path] = true
+		}
+		fmt.Fprintf(&methods, "\n// %s adds the values of the fields of %s tagged\n// with %q to %s; it implements %s.\n", t.method, s.name, t.key, t.param, t.iface)
+		fmt.Fprintf(&methods, "func (x %s) %s(%s %s) error {\n", s.name, t.method, t.param, t.typ)
+		methods.Write(body.Bytes())
+		fmt.Fprintf(&methods, "\treturn nil\n}\n")
+	}
+	if len(g.tags) > 0 {
+		fmt.Fprintf(&g.buf, "\nvar _%s_tags = [...]request.Tag{\n", s.name)
+		for _, tag := range g.tags {
+			fmt.Fprintf(&g.buf, "\trequest.NewTag(%s),\n", strconv.Quote(tag))
+		}
+		fmt.Fprintf(&g.buf, "}\n")
+	}
+	g.buf.Write(methods.Bytes())
+	return nil
+}
+
+// field writes the code adding the value of the field for the given tag, if
+// any.
+func (g *generator) field(w *bytes.Buffer, t target, f field) error {
+	var tag request.Tag
+	if ast.IsExported(f.name) {
+		var err error
+		if tag, err = request.ParseTag(f.tag.Get(t.key)); err != nil {
+			return fmt.Errorf("%s: field %s: %v", f.pos, f.name, err)
+		}
+	}
+	// otherwise the field embeds a struct of an unexported type, which is
+	// scanned as if untagged, since only its exported fields can be read
+	if tag.IsMissing() {
+		if !g.mayHoldStruct(f.typ) {
+			return nil
+		}
+		// untagged structs and pointers to structs are scanned recursively
+		value := "&x." + f.name
+		if _, ok := f.typ.(*ast.StarExpr); ok {
+			value = "x." + f.name
+		}
+		if t.assign {
+			fmt.Fprintf(w, "\tif err := request.AppendVariableStruct(%s, %s); err != nil {\n\t\treturn err\n\t}\n", t.param, value)
+		} else {
+			fmt.Fprintf(w, "\tif err := request.AppendStruct(%s, %q, %s); err != nil {\n\t\treturn err\n\t}\n", t.param, t.key, value)
+		}
+		return nil
+	}
+	if tag.IsIgnore() {
+		return nil
+	}
+	name := tag.Name()
+	if name == "" {
+		name = f.name
+	}
+	if g.native(w, t, f, tag, name) {
+		return nil
+	}
+	g.tags = append(g.tags, f.tag.Get(t.key))
+	if t.assign {
+		fmt.Fprintf(w, "\tif err := request.AppendVariableField(%s, %q, _%s_tags[%d], &x.%s); err != nil {\n\t\treturn err\n\t}\n", t.param, name, g.name, len(g.tags)-1, f.name)
+	} else {
+		fmt.Fprintf(w, "\tif err := request.AppendField(%s, %q, %q, _%s_tags[%d], &x.%s); err != nil {\n\t\treturn err\n\t}\n", t.param, t.key, name, g.name, len(g.tags)-1, f.name)
+	}
+	return nil
+}
+
+// native writes the code converting the value of the field inline, and returns
+// whether it could: only fields of builtin types, or of pointers to and slices
+// of them, without options affecting the conversion are handled.
+func (g *generator) native(w *bytes.Buffer, t target, f field, tag request.Tag, name string) bool {
+	if tag.IsInline() {
+		return false
+	}
+	for _, option := range []string{"format", "style", "explode"} {
+		if _, ok := tag.Option(option); ok {
+			return false
+		}
+	}
+
+	value := "x." + f.name
+	key := strconv.Quote(name)
+	add := func(indent, expr string) {
+		if t.assign {
+			fmt.Fprintf(w, "%s%s[%s] = %s\n", indent, t.param, key, expr)
+		} else {
+			fmt.Fprintf(w, "%s%s[%s] = append(%s[%s], %s)\n", indent, t.param, key, t.param, key, expr)
+		}
+	}
+	fail := func(indent string) {
+		fmt.Fprintf(w, "%sreturn &request.FieldError{Tag: %q, Name: %s, Err: request.ErrRequired}\n", indent, t.key, key)
+	}
+	d, hasDefault := tag.Default()
+
+	switch typ := f.typ.(type) {
+	case *ast.Ident:
+		if !g.isScalar(typ.Name) {
+			return false
+		}
+		switch {
+		case hasDefault:
+			fmt.Fprintf(w, "\tif %s {\n", isZero(typ.Name, value))
+			add("\t\t", strconv.Quote(d))
+			fmt.Fprintf(w, "\t} else {\n")
+			add("\t\t", g.format(typ.Name, value))
+			fmt.Fprintf(w, "\t}\n")
+		case tag.IsRequired():
+			fmt.Fprintf(w, "\tif %s {\n", isZero(typ.Name, value))
+			fail("\t\t")
+			fmt.Fprintf(w, "\t}\n")
+			add("\t", g.format(typ.Name, value))
+		case tag.IsOmitEmpty():
+			fmt.Fprintf(w, "\tif %s {\n", nonZero(typ.Name, value))
+			add("\t\t", g.format(typ.Name, value))
+			fmt.Fprintf(w, "\t}\n")
+		default:
+			add("\t", g.format(typ.Name, value))
+		}
+		return true
+
+	case *ast.StarExpr:
+		// nil pointers and pointers to zero values are skipped, unless the
+		// field has a default value or is required, respectively
+		elem, ok := typ.X.(*ast.Ident)
+		if !ok || !g.isScalar(elem.Name) {
+			return false
+		}
+		switch {
+		case hasDefault:
+			fmt.Fprintf(w, "\tif %s == nil {\n", value)
+			add("\t\t", strconv.Quote(d))
+			if tag.IsRequired() {
+				fmt.Fprintf(w, "\t} else {\n")
+			} else {
+				fmt.Fprintf(w, "\t} else if %s {\n", nonZero(elem.Name, "*"+value))
+			}
+			add("\t\t", g.format(elem.Name, "*"+value))
+			fmt.Fprintf(w, "\t}\n")
+		case tag.IsRequired():
+			fmt.Fprintf(w, "\tif %s == nil {\n", value)
+			fail("\t\t")
+			fmt.Fprintf(w, "\t}\n")
+			add("\t", g.format(elem.Name, "*"+value))
+		default:
+			fmt.Fprintf(w, "\tif %s != nil && %s {\n", value, nonZero(elem.Name, "*"+value))
+			add("\t\t", g.format(elem.Name, "*"+value))
+			fmt.Fprintf(w, "\t}\n")
+		}
+		return true
+
+	case *ast.ArrayType:
+		// slices of bytes are converted as strings, arrays are not handled
+		elem, ok := typ.Elt.(*ast.Ident)
+		if !ok || typ.Len != nil || elem.Name == "byte" || elem.Name == "uint8" || !g.isScalar(elem.Name) {
+			return false
+		}
+		if t.assign && tag.Delimiter() == "" {
+			// lists are set as such
+			return false
+		}
+		indent := "\t"
+		switch {
+		case hasDefault:
+			fmt.Fprintf(w, "\tif %s == nil {\n", value)
+			add("\t\t", strconv.Quote(d))
+			fmt.Fprintf(w, "\t} else {\n")
+			indent = "\t\t"
+		case tag.IsRequired():
+			fmt.Fprintf(w, "\tif %s == nil {\n", value)
+			fail("\t\t")
+			fmt.Fprintf(w, "\t}\n")
+		case tag.IsOmitEmpty():
+			fmt.Fprintf(w, "\tif %s != nil {\n", value)
+			indent = "\t\t"
+		}
+		if delimiter := tag.Delimiter(); delimiter != "" {
+			g.imports["strings"] = true
+			if elem.Name == "string" {
+				add(indent, fmt.Sprintf("strings.Join(%s, %s)", value, strconv.Quote(delimiter)))
+			} else {
+				fmt.Fprintf(w, "%s{\n", indent)
+				fmt.Fprintf(w, "%s\telements := make([]string, 0, len(%s))\n", indent, value)
+				fmt.Fprintf(w, "%s\tfor _, v := range %s {\n", indent, value)
+				fmt.Fprintf(w, "%s\t\telements = append(elements, %s)\n", indent, g.format(elem.Name, "v"))
+				fmt.Fprintf(w, "%s\t}\n", indent)
+				add(indent+"\t", fmt.Sprintf("strings.Join(elements, %s)", strconv.Quote(delimiter)))
+				fmt.Fprintf(w, "%s}\n", indent)
+			}
+		} else {
+			// the key is added even if there are no values
+			fmt.Fprintf(w, "%sif _, ok := %s[%s]; !ok {\n", indent, t.param, key)
+			fmt.Fprintf(w, "%s\t%s[%s] = []string{}\n", indent, t.param, key)
+			fmt.Fprintf(w, "%s}\n", indent)
+			if elem.Name == "string" {
+				add(indent, value+"...")
+			} else {
+				fmt.Fprintf(w, "%sfor _, v := range %s {\n", indent, value)
+				add(indent+"\t", g.format(elem.Name, "v"))
+				fmt.Fprintf(w, "%s}\n", indent)
+			}
+		}
+		if indent != "\t" {
+			fmt.Fprintf(w, "\t}\n")
+		}
+		return true
+	}
+	return false
+}
+
+// isScalar returns whether the type is a builtin type converted inline, and
+// is not shadowed by a type declared in the parsed files.
+func (g *generator) isScalar(typ string) bool {
+	_, ok := scalars[typ]
+	return ok && !g.others[typ]
+}
+
+// isZero returns the condition checking whether a value of a builtin type is
+// the zero value.
+func isZero(typ, value string) string {
+	if typ == "bool" {
+		return "!" + value
+	}
+	return value + " == " + scalars[typ]
+}
+
+// nonZero returns the condition checking whether a value of a builtin type is
+// not the zero value.
+func nonZero(typ, value string) string {
+	if typ == "bool" {
+		return value
+	}
+	return value + " != " + scalars[typ]
+}
+
+// format returns the expression converting the value of a builtin type into a
+// string, as the reflective path does.
+func (g *generator) format(typ, value string) string {
+	if typ == "string" {
+		return value
+	}
+	g.imports["strconv"] = true
+	switch typ {
+	case "bool":
+		return fmt.Sprintf("strconv.FormatBool(%s)", value)
+	case "int64":
+		return fmt.Sprintf("strconv.FormatInt(%s, 10)", value)
+	case "int", "int8", "int16", "int32", "rune":
+		return fmt.Sprintf("strconv.FormatInt(int64(%s), 10)", value)
+	case "uint64":
+		return fmt.Sprintf("strconv.FormatUint(%s, 10)", value)
+	case "float32":
+		return fmt.Sprintf("strconv.FormatFloat(float64(%s), 'f', -1, 32)", value)
+	case "float64":
+		return fmt.Sprintf("strconv.FormatFloat(%s, 'f', -1, 64)", value)
+	}
+	return fmt.Sprintf("strconv.FormatUint(uint64(%s), 10)", value)
+}
+
+// mayHoldStruct returns whether a field of the given type may hold a struct or
+// a pointer to a struct, that is scanned recursively if the field is untagged.
+func (g *generator) mayHoldStruct(typ ast.Expr) bool {
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.Ident:
+		return !predeclared[t.Name] && !g.others[t.Name]
+	case *ast.SelectorExpr, *ast.IndexExpr, *ast.StructType:
+		return true
+	}
+	return false
+}
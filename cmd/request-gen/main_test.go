@@ -0,0 +1,94 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	// the generated code in the request package must be up to date, since its
+	// tests check that it behaves exactly as the reflective path
+	src, first, err := generate([]string{"../../generate_test.go"}, []string{"genQuery", "genPaging", "genSearch"})
+	if err != nil {
+		t.Fatalf("error generating code: %v", err)
+	}
+	if first != "genQuery" {
+		t.Fatalf("invalid first type: expected \"genQuery\", got %q", first)
+	}
+	expected, err := ioutil.ReadFile("../../generate_request_test.go")
+	if err != nil {
+		t.Fatalf("error reading generated code: %v", err)
+	}
+	if !bytes.Equal(src, expected) {
+		t.Fatalf("generated code is out of date, run go generate")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "request-gen")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.go":              "package a\n\ntype A struct {\n\tName string `parameter:\"name\"`\n}\n\ntype B int\n",
+		"malformed.go":      "package a\n\ntype M struct {\n\tName string `parameter:\"name,bogus\"`\n}\n",
+		"other.go":          "package b\n",
+		"a_test.go":         "package a\n",
+		"a_request.go":      header + "\n\npackage a\n",
+		"untagged/plain.go": "package plain\n\ntype P struct {\n\tName string\n}\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+	path := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		return paths
+	}
+
+	if src, first, err := generate(path("a.go"), nil); err != nil || first != "A" || !bytes.Contains(src, []byte("func (x A) AppendParameters(parameters url.Values) error {")) {
+		t.Fatalf("expected methods for A, got %q (error: %v)", src, err)
+	}
+	tests := []struct {
+		files []string
+		types []string
+		err   string
+	}{
+		{path("malformed.go"), nil, "field Name"},
+		{path("a.go"), []string{"C"}, "struct type C not found"},
+		{path("a.go"), []string{"B"}, "struct type B not found"},
+		{path("a.go", "other.go"), nil, "different packages"},
+		{path("untagged/plain.go"), nil, "no structs with tagged fields"},
+	}
+	for i, test := range tests {
+		if _, _, err := generate(test.files, test.types); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("test %d: expected error containing %q, got %v", i, test.err, err)
+		}
+	}
+
+	// test files and generated files are skipped
+	files2, err := packageFiles(dir)
+	if err != nil {
+		t.Fatalf("error listing package files: %v", err)
+	}
+	if strings.Join(files2, ",") != strings.Join(path("a.go", "malformed.go", "other.go"), ",") {
+		t.Fatalf("invalid package files: got %v", files2)
+	}
+}
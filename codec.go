@@ -0,0 +1,228 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Codec marshals request entities and unmarshals response payloads for a given
+// media type; codecs are registered by media type via RegisterCodec(), and are
+// looked up by WithEntityAs() on the request side and by Response.Decode() on
+// the response side. Out of the box, codecs are provided for JSON, XML, URL-
+// encoded forms and plain text; other formats (e.g. YAML, MessagePack, CBOR or
+// protobuf) can be plugged in by registering a custom Codec.
+type Codec interface {
+	// ContentType returns the media type produced by the codec.
+	ContentType() string
+	// Marshal encodes the given value.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes the given data into the value, which must be a pointer.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// codecs is the registry of codecs, keyed by media type.
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: map[string]Codec{},
+}
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(XMLCodec{})
+	RegisterCodec(XMLCodec{MediaType: "text/xml"})
+	RegisterCodec(formCodec{})
+	RegisterCodec(textCodec{})
+}
+
+// RegisterCodec registers the codec under its own content type and under the
+// given additional media types, replacing any codec previously registered for
+// the same media types.
+func RegisterCodec(codec Codec, mediaTypes ...string) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	for _, mediaType := range append([]string{codec.ContentType()}, mediaTypes...) {
+		codecs.m[strings.ToLower(mediaType)] = codec
+	}
+}
+
+// LookupCodec returns the codec registered for the media type of the given
+// content type, ignoring any parameter (e.g. "charset"); if there is no codec
+// for the exact media type and it has a structured syntax suffix (as in
+// "application/problem+json"), the codec registered for "application/<suffix>"
+// is returned.
+func LookupCodec(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	if codec, ok := codecs.m[mediaType]; ok {
+		return codec, true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		codec, ok := codecs.m["application/"+mediaType[i+1:]]
+		return codec, ok
+	}
+	return nil, false
+}
+
+// JSONCodec is the Codec for "application/json"; its zero value encodes values
+// exactly like json.Marshal(), while its fields can be used to control the
+// encoder options.
+type JSONCodec struct {
+	// Prefix and Indent, if any of them is set, are used to indent the output
+	// as per json.MarshalIndent().
+	Prefix string
+	Indent string
+	// DisableHTMLEscaping prevents the escaping of <, > and & in JSON strings.
+	DisableHTMLEscaping bool
+	// DisallowUnknownFields makes decoding fail when the payload contains keys
+	// that do not match any field in the target struct.
+	DisallowUnknownFields bool
+}
+
+// ContentType returns "application/json".
+func (c JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// Marshal encodes the value as JSON according to the codec options.
+func (c JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(!c.DisableHTMLEscaping)
+	if c.Prefix != "" || c.Indent != "" {
+		encoder.SetIndent(c.Prefix, c.Indent)
+	}
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	// the encoder always appends a newline
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// Unmarshal decodes the JSON data into the value according to the codec options.
+func (c JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if c.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(v)
+}
+
+// XMLCodec is the Codec for XML media types; its zero value is registered for
+// "application/xml" and encodes values exactly like xml.Marshal(), while its
+// fields can be used to control the media type and the encoder options.
+type XMLCodec struct {
+	// MediaType is the media type of the codec, "application/xml" if empty.
+	MediaType string
+	// Prefix and Indent, if any of them is set, are used to indent the output
+	// as per xml.MarshalIndent().
+	Prefix string
+	Indent string
+	// Header prepends the standard XML header (xml.Header) to the output.
+	Header bool
+}
+
+// ContentType returns the codec media type.
+func (c XMLCodec) ContentType() string {
+	if c.MediaType == "" {
+		return "application/xml"
+	}
+	return c.MediaType
+}
+
+// Marshal encodes the value as XML according to the codec options.
+func (c XMLCodec) Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if c.Header {
+		buffer.WriteString(xml.Header)
+	}
+	encoder := xml.NewEncoder(&buffer)
+	if c.Prefix != "" || c.Indent != "" {
+		encoder.Indent(c.Prefix, c.Indent)
+	}
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal decodes the XML data into the value.
+func (c XMLCodec) Unmarshal(data []byte, v interface{}) error {
+	return xml.Unmarshal(data, v)
+}
+
+// formCodec is the codec for "application/x-www-form-urlencoded"; it marshals
+// structs tagged with "form" and maps, and unmarshals into *url.Values and
+// *map[string][]string.
+type formCodec struct{}
+
+func (formCodec) ContentType() string { return "application/x-www-form-urlencoded" }
+
+func (formCodec) Marshal(v interface{}) ([]byte, error) {
+	m, err := getValuesFrom("form", v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(url.Values(m).Encode()), nil
+}
+
+func (formCodec) Unmarshal(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case *url.Values:
+		*t = values
+	case *map[string][]string:
+		*t = values
+	default:
+		return fmt.Errorf("form-encoded payloads can only be decoded into *url.Values or *map[string][]string, not %T", v)
+	}
+	return nil
+}
+
+// textCodec is the codec for "text/plain"; it marshals strings, byte slices
+// and fmt.Stringers, and unmarshals into *string and *[]byte.
+type textCodec struct{}
+
+func (textCodec) ContentType() string { return "text/plain" }
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case string:
+		return []byte(t), nil
+	case []byte:
+		return t, nil
+	case fmt.Stringer:
+		return []byte(t.String()), nil
+	}
+	return nil, fmt.Errorf("only strings, byte slices and fmt.Stringers can be encoded as text, not %T", v)
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	switch t := v.(type) {
+	case *string:
+		*t = string(data)
+	case *[]byte:
+		*t = append((*t)[:0], data...)
+	default:
+		return fmt.Errorf("text payloads can only be decoded into *string or *[]byte, not %T", v)
+	}
+	return nil
+}
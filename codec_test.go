@@ -0,0 +1,102 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// csvCodec is a toy codec for "text/csv" payloads made of a single record.
+type csvCodec struct{}
+
+func (csvCodec) ContentType() string { return "text/csv" }
+
+func (csvCodec) Marshal(v interface{}) ([]byte, error) {
+	record, ok := v.([]string)
+	if !ok {
+		return nil, errors.New("only []string can be encoded as CSV")
+	}
+	return []byte(strings.Join(record, ",")), nil
+}
+
+func (csvCodec) Unmarshal(data []byte, v interface{}) error {
+	record, ok := v.(*[]string)
+	if !ok {
+		return errors.New("CSV can only be decoded into *[]string")
+	}
+	*record = strings.Split(string(data), ",")
+	return nil
+}
+
+func TestLookupCodec(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    string
+	}{
+		{"application/json", "application/json"},
+		{"application/json; charset=utf-8", "application/json"},
+		{"APPLICATION/JSON", "application/json"},
+		{"application/problem+json", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/xml", "text/xml"},
+		{"application/atom+xml", "application/xml"},
+		{"application/x-www-form-urlencoded", "application/x-www-form-urlencoded"},
+		{"text/plain; charset=utf-8", "text/plain"},
+	}
+	for _, test := range tests {
+		codec, ok := LookupCodec(test.contentType)
+		if !ok {
+			t.Fatalf("no codec found for %q", test.contentType)
+		}
+		if codec.ContentType() != test.expected {
+			t.Fatalf("invalid codec for %q: expected %q, got %q", test.contentType, test.expected, codec.ContentType())
+		}
+	}
+
+	for _, contentType := range []string{"application/octet-stream", "application/vnd.unknown+yaml", "", "invalid/"} {
+		if _, ok := LookupCodec(contentType); ok {
+			t.Fatalf("unexpected codec found for %q", contentType)
+		}
+	}
+}
+
+func TestWithEntityAs(t *testing.T) {
+	RegisterCodec(csvCodec{}, "application/csv")
+
+	f := New("").WithEntityAs("application/csv", []string{"a", "b", "c"})
+	data := readEntity(t, f)
+	if string(data) != "a,b,c" {
+		t.Fatalf("error adding entity via codec: expected \"a,b,c\", got %q", string(data))
+	}
+	if f.headers.Get("Content-Type") != "application/csv" {
+		t.Fatalf("error adding entity via codec: content type is %q, expected \"application/csv\"", f.headers.Get("Content-Type"))
+	}
+
+	f = New("").WithEntityAs("text/plain", "some text")
+	data = readEntity(t, f)
+	if string(data) != "some text" {
+		t.Fatalf("error adding text entity: expected \"some text\", got %q", string(data))
+	}
+
+	var entityErr *EntityError
+	if err := New("").WithEntityAs("text/csv", "not a record").Err(); !errors.As(err, &entityErr) {
+		t.Fatalf("expected entity error on marshalling failure, got %v", err)
+	}
+	if err := New("").WithEntityAs("application/x-unknown", "some text").Err(); !errors.As(err, &entityErr) {
+		t.Fatalf("expected entity error on unknown content type, got %v", err)
+	}
+
+	// the same codec is used on the response side
+	var record []string
+	if err := newTestResponse(http.StatusOK, "text/csv", "x,y").Decode(&record); err != nil {
+		t.Fatalf("error decoding CSV payload: %v", err)
+	}
+	if len(record) != 2 || record[0] != "x" || record[1] != "y" {
+		t.Fatalf("invalid decoded CSV record: got %q", record)
+	}
+}
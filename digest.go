@@ -0,0 +1,343 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest implements HTTP Digest authentication (RFC 7616) as a Middleware:
+// when the server responds with a 401 (Unauthorized) and a Digest challenge,
+// the request is replayed with the computed credentials; the challenge is then
+// remembered, so that following requests are authenticated preemptively with
+// an increasing nonce count until the server asks for a new nonce. MD5,
+// SHA-256 and SHA-512-256 (and their "-sess" variants) are supported, with
+// either "auth" or "auth-int" quality of protection.
+type Digest struct {
+	username  string
+	password  string
+	mutex     sync.Mutex
+	challenge *digestChallenge
+	count     int
+
+	// cnonce returns the client nonce; it can be overridden in tests.
+	cnonce func() string
+}
+
+// digestChallenge holds the parameters of a Digest challenge.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       []string
+	userhash  bool
+	stale     bool
+}
+
+// NewDigest returns a Digest authenticator with the given credentials; its
+// Middleware method can be passed to Builder.Use(), or Builder.DigestAuth() can
+// be used directly.
+func NewDigest(username, password string) *Digest {
+	return &Digest{
+		username: username,
+		password: password,
+	}
+}
+
+// Middleware authenticates the requests passing through it; requests whose
+// body cannot be replayed are not retried, and the 401 response is returned
+// as is.
+func (d *Digest) Middleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+
+		original := request
+		if authorization, err := d.authorization(request); err != nil {
+			return nil, err
+		} else if authorization != "" {
+			request = request.Clone(request.Context())
+			request.Header.Set("Authorization", authorization)
+		}
+		response, err := next.RoundTrip(request)
+		if err != nil || response.StatusCode != http.StatusUnauthorized || !replayable {
+			return response, err
+		}
+
+		challenge := parseDigestChallenge(response.Header.Values("WWW-Authenticate"))
+		if challenge == nil {
+			return response, nil
+		}
+		// a challenge that is identical to the one just answered means that the
+		// credentials are wrong, unless the server says the nonce is stale
+		if !d.update(challenge) {
+			return response, nil
+		}
+
+		retry := original.Clone(original.Context())
+		if original.GetBody != nil {
+			if retry.Body, err = original.GetBody(); err != nil {
+				return response, nil
+			}
+		}
+		authorization, err := d.authorization(retry)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		retry.Header.Set("Authorization", authorization)
+		return next.RoundTrip(retry)
+	})
+}
+
+// update stores the given challenge, resetting the nonce count if the nonce
+// has changed; it returns whether the request should be retried.
+func (d *Digest) update(challenge *digestChallenge) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	retry := d.challenge == nil || d.challenge.nonce != challenge.nonce || challenge.stale
+	if d.challenge == nil || d.challenge.nonce != challenge.nonce {
+		d.count = 0
+	}
+	d.challenge = challenge
+	return retry
+}
+
+// authorization returns the value of the Authorization header for the given
+// request, or an empty string if no challenge has been received yet.
+func (d *Digest) authorization(request *http.Request) (string, error) {
+	d.mutex.Lock()
+	challenge := d.challenge
+	if challenge == nil {
+		d.mutex.Unlock()
+		return "", nil
+	}
+	d.count++
+	count := d.count
+	d.mutex.Unlock()
+
+	newHash := digestHash(challenge.algorithm)
+	if newHash == nil {
+		return "", fmt.Errorf("unsupported digest algorithm %q", challenge.algorithm)
+	}
+	h := func(values ...string) string {
+		hash := newHash()
+		io.WriteString(hash, strings.Join(values, ":"))
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+
+	cnonce := newDigestNonce
+	if d.cnonce != nil {
+		cnonce = d.cnonce
+	}
+	nc := fmt.Sprintf("%08x", count)
+	uri := request.URL.RequestURI()
+
+	qop := ""
+	for _, value := range challenge.qop {
+		if value == "auth" {
+			qop = value
+			break
+		}
+		if value == "auth-int" {
+			qop = value
+		}
+	}
+
+	client := cnonce()
+	ha1 := h(d.username, challenge.realm, d.password)
+	if strings.HasSuffix(strings.ToLower(challenge.algorithm), "-sess") {
+		ha1 = h(ha1, challenge.nonce, client)
+	}
+	ha2 := h(request.Method, uri)
+	if qop == "auth-int" {
+		body, err := BodyDigest(request, newHash())
+		if err != nil {
+			return "", err
+		}
+		ha2 = h(request.Method, uri, hex.EncodeToString(body))
+	}
+	var response string
+	if qop == "" {
+		response = h(ha1, challenge.nonce, ha2)
+	} else {
+		response = h(ha1, challenge.nonce, nc, client, qop, ha2)
+	}
+
+	username := d.username
+	if challenge.userhash {
+		username = h(d.username, challenge.realm)
+	}
+	parameters := []string{
+		"username=" + quoteDigest(username),
+		"realm=" + quoteDigest(challenge.realm),
+		"uri=" + quoteDigest(uri),
+	}
+	if challenge.algorithm != "" {
+		parameters = append(parameters, "algorithm="+challenge.algorithm)
+	}
+	parameters = append(parameters, "nonce="+quoteDigest(challenge.nonce))
+	if qop != "" {
+		parameters = append(parameters, "nc="+nc, "cnonce="+quoteDigest(client), "qop="+qop)
+	}
+	parameters = append(parameters, "response="+quoteDigest(response))
+	if challenge.opaque != "" {
+		parameters = append(parameters, "opaque="+quoteDigest(challenge.opaque))
+	}
+	if challenge.userhash {
+		parameters = append(parameters, "userhash=true")
+	}
+	return "Digest " + strings.Join(parameters, ", "), nil
+}
+
+// digestHash returns the hash function for the given Digest algorithm, or nil
+// if the algorithm is not supported; MD5 is the default.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	}
+	return nil
+}
+
+// parseDigestChallenge returns the strongest supported Digest challenge among
+// the given WWW-Authenticate header values, or nil if there is none.
+func parseDigestChallenge(values []string) *digestChallenge {
+	var best *digestChallenge
+	rank := func(algorithm string) int {
+		switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+		case "SHA-512-256":
+			return 3
+		case "SHA-256":
+			return 2
+		}
+		return 1
+	}
+	for _, value := range values {
+		for _, challenge := range splitChallenges(value) {
+			if len(challenge) < 7 || !strings.EqualFold(challenge[:7], "digest ") {
+				continue
+			}
+			parameters := parseAuthParameters(challenge[7:])
+			c := &digestChallenge{
+				realm:     parameters["realm"],
+				nonce:     parameters["nonce"],
+				opaque:    parameters["opaque"],
+				algorithm: parameters["algorithm"],
+				userhash:  strings.EqualFold(parameters["userhash"], "true"),
+				stale:     strings.EqualFold(parameters["stale"], "true"),
+			}
+			for _, qop := range strings.Split(parameters["qop"], ",") {
+				if qop = strings.TrimSpace(qop); qop != "" {
+					c.qop = append(c.qop, qop)
+				}
+			}
+			if c.nonce == "" || digestHash(c.algorithm) == nil {
+				continue
+			}
+			if best == nil || rank(c.algorithm) > rank(best.algorithm) {
+				best = c
+			}
+		}
+	}
+	return best
+}
+
+// splitChallenges splits a WWW-Authenticate header value into its challenges,
+// which start with an authentication scheme followed by a space, while
+// parameters are separated by commas, possibly inside quoted strings.
+func splitChallenges(value string) []string {
+	var challenges []string
+	for _, item := range splitQuoted(value, ',') {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if i := strings.IndexAny(item, " ="); i >= 0 && item[i] == ' ' || len(challenges) == 0 {
+			challenges = append(challenges, item)
+		} else {
+			challenges[len(challenges)-1] += ", " + item
+		}
+	}
+	return challenges
+}
+
+// parseAuthParameters parses a comma-separated list of authentication
+// parameters, unquoting quoted values; names are lowercased.
+func parseAuthParameters(value string) map[string]string {
+	parameters := map[string]string{}
+	for _, item := range splitQuoted(value, ',') {
+		pair := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		name, value := pair[0], strings.TrimSpace(pair[1])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			var buffer strings.Builder
+			for i := 1; i < len(value)-1; i++ {
+				if value[i] == '\\' && i+1 < len(value)-1 {
+					i++
+				}
+				buffer.WriteByte(value[i])
+			}
+			value = buffer.String()
+		}
+		parameters[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return parameters
+}
+
+// splitQuoted splits the value at each separator that is not inside a quoted
+// string.
+func splitQuoted(value string, separator byte) []string {
+	var items []string
+	quoted, start := false, 0
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quoted:
+			i++
+		case value[i] == '"':
+			quoted = !quoted
+		case value[i] == separator && !quoted:
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, value[start:])
+}
+
+// quoteDigest returns the value as a quoted string.
+func quoteDigest(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// newDigestNonce returns a random client nonce.
+func newDigestNonce() string {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		// extremely unlikely, fall back to a time-based nonce
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return base64.StdEncoding.EncodeToString(nonce)
+}
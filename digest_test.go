@@ -0,0 +1,136 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestDigestAuthorization uses the examples from RFC 7616, section 3.9.1.
+func TestDigestAuthorization(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+	for _, test := range tests {
+		header := fmt.Sprintf(`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=%s, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`, test.algorithm)
+		challenge := parseDigestChallenge([]string{header})
+		if challenge == nil {
+			t.Fatalf("%s: challenge not parsed", test.algorithm)
+		}
+		digest := NewDigest("Mufasa", "Circle of Life")
+		digest.cnonce = func() string { return "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ" }
+		digest.update(challenge)
+
+		req, _ := http.NewRequest(http.MethodGet, "http://www.example.org/dir/index.html", nil)
+		authorization, err := digest.authorization(req)
+		if err != nil {
+			t.Fatalf("%s: error computing authorization: %v", test.algorithm, err)
+		}
+		expected := `Digest username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", algorithm=` + test.algorithm +
+			`, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", nc=00000001, cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", qop=auth` +
+			`, response="` + test.response + `", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+		if authorization != expected {
+			t.Fatalf("%s: invalid authorization:\nexpected %q\ngot      %q", test.algorithm, expected, authorization)
+		}
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	challenge := parseDigestChallenge([]string{
+		`Basic realm="basic", Digest realm="weak", nonce="n1", qop="auth"`,
+		`Digest realm="strong, really", nonce="n2", algorithm=SHA-256, userhash=true, stale=TRUE`,
+		`Digest realm="unsupported", nonce="n3", algorithm=SHA-1`,
+	})
+	if challenge == nil {
+		t.Fatalf("challenge not parsed")
+	}
+	if challenge.realm != "strong, really" || challenge.nonce != "n2" || !challenge.userhash || !challenge.stale {
+		t.Fatalf("invalid challenge: got %+v", challenge)
+	}
+	if parseDigestChallenge([]string{`Basic realm="basic"`}) != nil {
+		t.Fatalf("expected no challenge")
+	}
+}
+
+// digestServer returns a test server that requires Digest authentication with
+// MD5, and the number of challenges it has issued.
+func digestServer(t *testing.T, username, password string) (*httptest.Server, func() int) {
+	var mutex sync.Mutex
+	challenges := 0
+	counts := map[string]bool{}
+	h := func(values ...string) string {
+		sum := md5.Sum([]byte(strings.Join(values, ":")))
+		return hex.EncodeToString(sum[:])
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		p := parseAuthParameters(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "))
+		expected := h(h(username, "test", password), "nonce", p["nc"], p["cnonce"], "auth", h(r.Method, r.URL.RequestURI()))
+		if p["response"] != expected || p["uri"] != r.URL.RequestURI() || counts[p["nc"]] {
+			challenges++
+			w.Header().Set("WWW-Authenticate", `Digest realm="test", nonce="nonce", qop="auth", algorithm=MD5`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		counts[p["nc"]] = true
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+	return server, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return challenges
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	server, challenges := digestServer(t, "user", "pass")
+	defer server.Close()
+
+	parent := New(server.URL).DigestAuth("user", "pass")
+	for i, f := range []*Builder{
+		parent.New("", "/first").Post().WithEntity(strings.NewReader("hello")),
+		parent.New("", "/second").Get(),
+		parent.New("", "/third").Get(),
+	} {
+		res, err := f.Do(context.Background())
+		if err != nil {
+			t.Fatalf("error submitting request %d: %v", i, err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, res.StatusCode)
+		}
+		if i == 0 && res.String() != "POST hello" {
+			t.Fatalf("body not replayed: got %q", res.String())
+		}
+	}
+	// only the first request is challenged, the others are preemptively
+	// authenticated with increasing nonce counts
+	if challenges() != 1 {
+		t.Fatalf("expected 1 challenge, got %d", challenges())
+	}
+
+	res, err := New(server.URL).DigestAuth("user", "wrong").Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 with wrong credentials, got %d", res.StatusCode)
+	}
+}
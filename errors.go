@@ -0,0 +1,209 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors is the list of errors accumulated by a Builder along a chain of calls;
+// it is returned by Err() and Make() when more than one failure occurred.
+type Errors []error
+
+// Error returns all accumulated errors as a single string.
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the accumulated errors, so that errors.Is() and errors.As()
+// can inspect each of them.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// URLError is recorded when the builder URL or a path cannot be parsed or bound
+// to the builder variables.
+type URLError struct {
+	URL string
+	Err error
+}
+
+// Error returns the URLError as a string.
+func (e *URLError) Error() string {
+	return fmt.Sprintf("invalid URL %q: %v", e.URL, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *URLError) Unwrap() error {
+	return e.Err
+}
+
+// ErrDotSegment is wrapped in the *URLError recorded when the value of a variable
+// expanded as (or into) path segments is or contains a dot segment ("." or
+// ".."), which servers would resolve against the path of the URL even if it
+// were percent-encoded.
+var ErrDotSegment = errors.New("value is or contains a dot segment")
+
+// BindingError is returned by Make() in strict mode when the URL template has
+// placeholders with no matching variable, or variables that are not used by any
+// placeholder.
+type BindingError struct {
+	URL     string
+	Unbound []string
+	Unused  []string
+}
+
+// Error returns the BindingError as a string.
+func (e *BindingError) Error() string {
+	var messages []string
+	if len(e.Unbound) > 0 {
+		messages = append(messages, "unbound placeholders: "+strings.Join(e.Unbound, ", "))
+	}
+	if len(e.Unused) > 0 {
+		messages = append(messages, "unused variables: "+strings.Join(e.Unused, ", "))
+	}
+	return fmt.Sprintf("invalid variables for URL %q: %s", e.URL, strings.Join(messages, "; "))
+}
+
+// PatternError is recorded when the regular expression used to remove query
+// parameters, headers or variables via Remove() cannot be compiled.
+type PatternError struct {
+	Pattern string
+	Err     error
+}
+
+// Error returns the PatternError as a string.
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("invalid pattern %q: %v", e.Pattern, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+// SourceError is recorded when the source of query parameters, headers or
+// variables is neither a struct nor a map[string][]string (or a pointer to one
+// of them).
+type SourceError struct {
+	Tag    string
+	Source interface{}
+}
+
+// Error returns the SourceError as a string.
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("only structs and maps can be passed as sources for %q values, not %T", e.Tag, e.Source)
+}
+
+// TagError is recorded when the tag of a struct field is malformed (see Tag
+// for its grammar).
+type TagError struct {
+	Field string
+	Tag   string
+	Err   error
+}
+
+// Error returns the TagError as a string.
+func (e *TagError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("invalid tag %q on field %q: %v", e.Tag, e.Field, e.Err)
+	}
+	return fmt.Sprintf("invalid tag %q: %v", e.Tag, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
+
+// ErrRequired is wrapped in the *FieldError recorded when a field tagged as
+// "required" has a zero value or a nil reference.
+var ErrRequired = errors.New("missing value for required field")
+
+// FieldError is recorded when the value of a tagged struct field cannot be
+// converted into query parameters, headers, variables or form fields.
+type FieldError struct {
+	Tag  string
+	Name string
+	Err  error
+}
+
+// Error returns the FieldError as a string.
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid value for %q %q: %v", e.Tag, e.Name, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// EntityError is recorded when the request entity cannot be encoded.
+type EntityError struct {
+	Format string
+	Entity interface{}
+	Err    error
+}
+
+// Error returns the EntityError as a string.
+func (e *EntityError) Error() string {
+	return fmt.Sprintf("invalid %s entity of type %T: %v", e.Format, e.Entity, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EntityError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNoToken is wrapped by the *AuthError returned when a TokenSource returns
+// neither a token nor an error.
+var ErrNoToken = errors.New("token source returned no token")
+
+// AuthError is returned when the authentication token cannot be obtained.
+type AuthError struct {
+	Err error
+}
+
+// Error returns the AuthError as a string.
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("error obtaining authentication token: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// ResponseSizeError is returned by Do() when the response body is larger than
+// the limit set via MaxResponseSize().
+type ResponseSizeError struct {
+	Limit int64
+}
+
+// Error returns the ResponseSizeError as a string.
+func (e *ResponseSizeError) Error() string {
+	return fmt.Sprintf("response body exceeds the limit of %d bytes", e.Limit)
+}
+
+// SignatureError is returned when a request cannot be signed.
+type SignatureError struct {
+	Err error
+}
+
+// Error returns the SignatureError as a string.
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("error signing request: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
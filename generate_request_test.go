@@ -0,0 +1,225 @@
+// Code generated by request-gen. DO NOT EDIT.
+
+package request_test
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	request "github.com/dihedron/go-request"
+)
+
+var _genQuery_tags = [...]request.Tag{
+	request.NewTag("filter,style=deepObject"),
+	request.NewTag("since,format=date"),
+	request.NewTag("color,format=hex"),
+	request.NewTag("level,omitempty"),
+	request.NewTag("data,omitempty"),
+	request.NewTag(",inline"),
+	request.NewTag("X-Any,omitempty"),
+	request.NewTag("path"),
+	request.NewTag("facets"),
+}
+
+// AppendParameters adds the values of the fields of genQuery tagged
+// with "parameter" to parameters; it implements request.ParameterAppender.
+func (x genQuery) AppendParameters(parameters url.Values) error {
+	if err := request.AppendStruct(parameters, "parameter", &x.genPaging); err != nil {
+		return err
+	}
+	if err := request.AppendStruct(parameters, "parameter", &x.Paging); err != nil {
+		return err
+	}
+	if err := request.AppendStruct(parameters, "parameter", x.Cursor); err != nil {
+		return err
+	}
+	if err := request.AppendField(parameters, "parameter", "filter", _genQuery_tags[0], &x.Filter); err != nil {
+		return err
+	}
+	if x.Owner == "" {
+		return &request.FieldError{Tag: "parameter", Name: "owner", Err: request.ErrRequired}
+	}
+	parameters["owner"] = append(parameters["owner"], x.Owner)
+	if x.Query != "" {
+		parameters["q"] = append(parameters["q"], x.Query)
+	}
+	parameters["status"] = append(parameters["status"], strings.Join(x.Status, ","))
+	if _, ok := parameters["label"]; !ok {
+		parameters["label"] = []string{}
+	}
+	parameters["label"] = append(parameters["label"], x.Labels...)
+	if x.IDs != nil {
+		if _, ok := parameters["id"]; !ok {
+			parameters["id"] = []string{}
+		}
+		for _, v := range x.IDs {
+			parameters["id"] = append(parameters["id"], strconv.FormatInt(v, 10))
+		}
+	}
+	if x.Codes == nil {
+		parameters["code"] = append(parameters["code"], "200")
+	} else {
+		if _, ok := parameters["code"]; !ok {
+			parameters["code"] = []string{}
+		}
+		for _, v := range x.Codes {
+			parameters["code"] = append(parameters["code"], strconv.FormatUint(uint64(v), 10))
+		}
+	}
+	if x.Ratio != 0 {
+		parameters["ratio"] = append(parameters["ratio"], strconv.FormatFloat(x.Ratio, 'f', -1, 64))
+	}
+	parameters["scale"] = append(parameters["scale"], strconv.FormatFloat(float64(x.Scale), 'f', -1, 32))
+	if x.Weight != nil && *x.Weight != 0 {
+		parameters["weight"] = append(parameters["weight"], strconv.FormatFloat(float64(*x.Weight), 'f', -1, 32))
+	}
+	if x.Active == nil {
+		parameters["active"] = append(parameters["active"], "true")
+	} else if *x.Active {
+		parameters["active"] = append(parameters["active"], strconv.FormatBool(*x.Active))
+	}
+	if x.Count == nil {
+		return &request.FieldError{Tag: "parameter", Name: "count", Err: request.ErrRequired}
+	}
+	parameters["count"] = append(parameters["count"], strconv.FormatInt(int64(*x.Count), 10))
+	if !x.Deleted {
+		parameters["deleted"] = append(parameters["deleted"], "false")
+	} else {
+		parameters["deleted"] = append(parameters["deleted"], strconv.FormatBool(x.Deleted))
+	}
+	if err := request.AppendField(parameters, "parameter", "since", _genQuery_tags[1], &x.Since); err != nil {
+		return err
+	}
+	if err := request.AppendField(parameters, "parameter", "color", _genQuery_tags[2], &x.Color); err != nil {
+		return err
+	}
+	if err := request.AppendField(parameters, "parameter", "level", _genQuery_tags[3], &x.Level); err != nil {
+		return err
+	}
+	if err := request.AppendField(parameters, "parameter", "data", _genQuery_tags[4], &x.Data); err != nil {
+		return err
+	}
+	if err := request.AppendField(parameters, "parameter", "Extra", _genQuery_tags[5], &x.Extra); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AppendHeaders adds the values of the fields of genQuery tagged
+// with "header" to headers; it implements request.HeaderAppender.
+func (x genQuery) AppendHeaders(headers http.Header) error {
+	if err := request.AppendStruct(headers, "header", &x.genPaging); err != nil {
+		return err
+	}
+	if err := request.AppendStruct(headers, "header", &x.Paging); err != nil {
+		return err
+	}
+	if err := request.AppendStruct(headers, "header", x.Cursor); err != nil {
+		return err
+	}
+	if err := request.AppendStruct(headers, "header", &x.Filter); err != nil {
+		return err
+	}
+	headers["X-Owner"] = append(headers["X-Owner"], x.Owner)
+	if err := request.AppendStruct(headers, "header", &x.Since); err != nil {
+		return err
+	}
+	if x.Tenant != "" {
+		headers["X-Tenant"] = append(headers["X-Tenant"], x.Tenant)
+	}
+	if x.Trace != nil && *x.Trace != "" {
+		headers["X-Trace-Id"] = append(headers["X-Trace-Id"], *x.Trace)
+	}
+	headers["X-Tags"] = append(headers["X-Tags"], strings.Join(x.Tags, "; "))
+	if err := request.AppendField(headers, "header", "X-Any", _genQuery_tags[6], &x.Any); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AppendVariables adds the values of the fields of genQuery tagged
+// with "variable" to variables; it implements request.VariableAppender.
+func (x genQuery) AppendVariables(variables map[string]interface{}) error {
+	if err := request.AppendVariableStruct(variables, &x.genPaging); err != nil {
+		return err
+	}
+	if err := request.AppendVariableStruct(variables, &x.Paging); err != nil {
+		return err
+	}
+	if err := request.AppendVariableStruct(variables, x.Cursor); err != nil {
+		return err
+	}
+	if err := request.AppendVariableStruct(variables, &x.Filter); err != nil {
+		return err
+	}
+	if err := request.AppendVariableStruct(variables, &x.Since); err != nil {
+		return err
+	}
+	variables["tenant"] = x.Tenant
+	if x.Version == 0 {
+		variables["version"] = "1"
+	} else {
+		variables["version"] = strconv.FormatUint(uint64(x.Version), 10)
+	}
+	if err := request.AppendVariableField(variables, "path", _genQuery_tags[7], &x.Path); err != nil {
+		return err
+	}
+	variables["scopes"] = strings.Join(x.Scopes, " ")
+	if err := request.AppendVariableField(variables, "facets", _genQuery_tags[8], &x.Facets); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AppendParameters adds the values of the fields of genPaging tagged
+// with "parameter" to parameters; it implements request.ParameterAppender.
+func (x genPaging) AppendParameters(parameters url.Values) error {
+	if x.Page != 0 {
+		parameters["page"] = append(parameters["page"], strconv.FormatInt(int64(x.Page), 10))
+	}
+	if x.Limit == 0 {
+		parameters["limit"] = append(parameters["limit"], "20")
+	} else {
+		parameters["limit"] = append(parameters["limit"], strconv.FormatInt(int64(x.Limit), 10))
+	}
+	return nil
+}
+
+// AppendParameters adds the values of the fields of genSearch tagged
+// with "parameter" to parameters; it implements request.ParameterAppender.
+func (x genSearch) AppendParameters(parameters url.Values) error {
+	if x.Query == "" {
+		return &request.FieldError{Tag: "parameter", Name: "q", Err: request.ErrRequired}
+	}
+	parameters["q"] = append(parameters["q"], x.Query)
+	parameters["fields"] = append(parameters["fields"], strings.Join(x.Fields, ","))
+	if x.Sort != nil {
+		if _, ok := parameters["sort"]; !ok {
+			parameters["sort"] = []string{}
+		}
+		parameters["sort"] = append(parameters["sort"], x.Sort...)
+	}
+	if x.Page != 0 {
+		parameters["page"] = append(parameters["page"], strconv.FormatInt(int64(x.Page), 10))
+	}
+	if x.Limit == 0 {
+		parameters["limit"] = append(parameters["limit"], "20")
+	} else {
+		parameters["limit"] = append(parameters["limit"], strconv.FormatInt(int64(x.Limit), 10))
+	}
+	if x.Exact != nil && *x.Exact {
+		parameters["exact"] = append(parameters["exact"], strconv.FormatBool(*x.Exact))
+	}
+	return nil
+}
+
+// AppendHeaders adds the values of the fields of genSearch tagged
+// with "header" to headers; it implements request.HeaderAppender.
+func (x genSearch) AppendHeaders(headers http.Header) error {
+	if x.Trace != "" {
+		headers["X-Trace-Id"] = append(headers["X-Trace-Id"], x.Trace)
+	}
+	return nil
+}
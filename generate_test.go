@@ -0,0 +1,216 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request_test
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	request "github.com/dihedron/go-request"
+)
+
+//go:generate go run ./cmd/request-gen -type=genQuery,genPaging,genSearch -output=generate_request_test.go generate_test.go
+
+type genLevel int
+
+func (l genLevel) String() string {
+	return [...]string{"low", "medium", "high"}[l]
+}
+
+type genFilter struct {
+	Status string   `parameter:"status,omitempty"`
+	Tags   []string `parameter:"tags"`
+}
+
+type genPaging struct {
+	Page  int `parameter:"page,omitempty"`
+	Limit int `parameter:"limit,default=20"`
+}
+
+type genQuery struct {
+	genPaging
+	Paging  genPaging
+	Cursor  *genPaging
+	Filter  genFilter         `parameter:"filter,style=deepObject"`
+	Owner   string            `parameter:"owner,required" header:"X-Owner"`
+	Query   string            `parameter:"q,omitempty"`
+	Status  []string          `parameter:"status,comma"`
+	Labels  []string          `parameter:"label"`
+	IDs     []int64           `parameter:"id,omitempty"`
+	Codes   []uint16          `parameter:"code,default=200"`
+	Ratio   float64           `parameter:"ratio,omitempty"`
+	Scale   float32           `parameter:"scale"`
+	Weight  *float32          `parameter:"weight"`
+	Active  *bool             `parameter:"active,default=true"`
+	Count   *int              `parameter:"count,required"`
+	Deleted bool              `parameter:"deleted,default=false"`
+	Since   time.Time         `parameter:"since,format=date"`
+	Color   int               `parameter:"color,format=hex"`
+	Level   genLevel          `parameter:"level,omitempty"`
+	Data    []byte            `parameter:"data,omitempty"`
+	Extra   map[string]string `parameter:",inline"`
+	Ignored string            `parameter:"-"`
+	Tenant  string            `variable:"tenant" header:"X-Tenant,omitempty"`
+	Version uint              `variable:"version,default=1"`
+	Path    []string          `variable:"path"`
+	Scopes  []string          `variable:"scopes,space"`
+	Facets  map[string]int    `variable:"facets"`
+	Trace   *string           `header:"X-Trace-Id"`
+	Tags    []string          `header:"X-Tags,delimiter='; '"`
+	Any     interface{}       `header:"X-Any,omitempty"`
+	hidden  string
+}
+
+type genSearch struct {
+	Query  string   `parameter:"q,required"`
+	Fields []string `parameter:"fields,comma"`
+	Sort   []string `parameter:"sort,omitempty"`
+	Page   int      `parameter:"page,omitempty"`
+	Limit  int      `parameter:"limit,default=20"`
+	Exact  *bool    `parameter:"exact"`
+	Trace  string   `header:"X-Trace-Id,omitempty"`
+}
+
+func TestGeneratedAppenders(t *testing.T) {
+	weight := float32(0.5)
+	active := false
+	count := 0
+	trace := ""
+	full := genQuery{
+		genPaging: genPaging{Page: 7},
+		Paging:    genPaging{Page: 2, Limit: 50},
+		Cursor:    &genPaging{Page: 3},
+		Filter:    genFilter{Status: "open", Tags: []string{"a", "b"}},
+		Owner:     "me",
+		Query:     "books",
+		Status:    []string{"open", "closed"},
+		Labels:    []string{"x", "y"},
+		IDs:       []int64{-1, 2},
+		Codes:     []uint16{404},
+		Ratio:     -0.25,
+		Scale:     1.5,
+		Weight:    &weight,
+		Active:    &active,
+		Count:     &count,
+		Deleted:   true,
+		Since:     time.Date(2021, 4, 20, 2, 7, 55, 0, time.UTC),
+		Color:     255,
+		Level:     2,
+		Data:      []byte("raw"),
+		Extra:     map[string]string{"x": "1"},
+		Ignored:   "ignored",
+		Tenant:    "acme",
+		Version:   3,
+		Path:      []string{"a", "b"},
+		Scopes:    []string{"read", "write"},
+		Facets:    map[string]int{"size": 10},
+		Trace:     &trace,
+		Tags:      []string{"t1", "t2"},
+		Any:       0,
+		hidden:    "hidden",
+	}
+	sparse := genQuery{
+		Owner:  "me",
+		Count:  &count,
+		Labels: []string{},
+		Tags:   []string{},
+		Path:   []string{},
+	}
+	for i, source := range []genQuery{full, sparse, {Owner: "me", Count: &count}} {
+		for _, test := range []struct {
+			key      string
+			appender func(map[string][]string) error
+		}{
+			{"parameter", func(values map[string][]string) error { return source.AppendParameters(values) }},
+			{"header", func(values map[string][]string) error { return source.AppendHeaders(values) }},
+		} {
+			expected, err := request.GetValuesFromStruct(test.key, source)
+			if err != nil {
+				t.Fatalf("test %d: error getting %q values via reflection: %v", i, test.key, err)
+			}
+			actual := map[string][]string{}
+			if err := test.appender(actual); err != nil {
+				t.Fatalf("test %d: error appending %q values: %v", i, test.key, err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("test %d: invalid %q values: expected %#v, got %#v", i, test.key, expected, actual)
+			}
+		}
+		expected, err := request.GetVariablesFromStruct(source)
+		if err != nil {
+			t.Fatalf("test %d: error getting variables via reflection: %v", i, err)
+		}
+		actual := map[string]interface{}{}
+		if err := source.AppendVariables(actual); err != nil {
+			t.Fatalf("test %d: error appending variables: %v", i, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("test %d: invalid variables: expected %#v, got %#v", i, expected, actual)
+		}
+	}
+
+	exact := false
+	for i, source := range []genSearch{{Query: "books", Fields: []string{"a", "b"}, Sort: []string{}, Page: 1, Exact: &exact, Trace: "abc"}, {Query: "books"}} {
+		expected, err := request.GetValuesFromStruct("parameter", source)
+		if err != nil {
+			t.Fatalf("test %d: error getting values via reflection: %v", i, err)
+		}
+		actual := url.Values{}
+		if err := source.AppendParameters(actual); err != nil {
+			t.Fatalf("test %d: error appending values: %v", i, err)
+		}
+		if !reflect.DeepEqual(map[string][]string(actual), expected) {
+			t.Fatalf("test %d: invalid values: expected %#v, got %#v", i, expected, actual)
+		}
+	}
+
+	// required values are checked
+	var fieldErr *request.FieldError
+	for _, source := range []genQuery{{Count: &count}, {Owner: "me"}} {
+		if err := source.AppendParameters(map[string][]string{}); !errors.As(err, &fieldErr) || !errors.Is(err, request.ErrRequired) {
+			t.Fatalf("expected *FieldError wrapping ErrRequired, got %v", err)
+		}
+	}
+
+	// the builder uses the generated methods
+	req, err := request.New("https://www.example.com/{tenant}/v{version}{/path*}").
+		QueryParametersFrom(sparse).
+		HeadersFrom(&sparse).
+		VariablesFrom(genQuery{Tenant: "acme", Path: []string{"a", "b"}}).
+		Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if query := req.URL.Query(); req.URL.Path != "/acme/v1/a/b" || query.Get("owner") != "me" || query.Get("limit") != "20" || query.Get("code") != "200" {
+		t.Fatalf("invalid URL: got %q", req.URL.String())
+	}
+	if req.Header.Get("X-Owner") != "me" {
+		t.Fatalf("invalid headers: got %v", req.Header)
+	}
+}
+
+func BenchmarkGeneratedAppender(b *testing.B) {
+	exact := true
+	source := genSearch{Query: "books", Fields: []string{"title", "author"}, Sort: []string{"-date"}, Page: 2, Exact: &exact, Trace: "abc"}
+	b.Run("generated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := source.AppendParameters(map[string][]string{}); err != nil {
+				b.Fatalf("error appending values: %v", err)
+			}
+		}
+	})
+	b.Run("reflective", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := request.GetValuesFromStruct("parameter", source); err != nil {
+				b.Fatalf("error getting values: %v", err)
+			}
+		}
+	})
+}
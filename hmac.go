@@ -0,0 +1,154 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryCanonicalization is how query parameters are represented in the
+// canonical form of a request signed by an HMACSigner.
+type QueryCanonicalization int
+
+const (
+	// SortedQuery sorts the query parameters by key and value, and URI-encodes
+	// them, so that the signature does not depend on their order.
+	SortedQuery QueryCanonicalization = iota
+	// RawQuery uses the query string exactly as it is sent.
+	RawQuery
+	// NoQuery leaves the query string out of the signature.
+	NoQuery
+)
+
+// HMACSigner signs requests with an HMAC over a canonical form of the request,
+// made of the following lines:
+//   - the method;
+//   - the escaped path;
+//   - the query string, according to Query;
+//   - one "name:value" line per signed header, with the lowercase name and the
+//     values trimmed, with sequential spaces collapsed and joined by commas;
+//   - the hex-encoded SHA-256 digest of the body, if Body is set.
+//
+// The signature is sent as <Scheme> keyId="...", headers="...", signature="..."
+// in the Authorization header, or as is in any other header.
+type HMACSigner struct {
+	// KeyID identifies the key to the server.
+	KeyID string
+	// Key is the shared secret.
+	Key []byte
+	// Hash is the hash function, SHA-256 if nil.
+	Hash func() hash.Hash
+	// Headers is the list of signed headers, in order; a missing header makes
+	// signing fail.
+	Headers []string
+	// Timestamp, if not empty, is the name of a header that is set to the
+	// current Unix time and signed along with the others, to prevent replays.
+	Timestamp string
+	// Query is how query parameters are canonicalized.
+	Query QueryCanonicalization
+	// Body is whether the digest of the body is signed.
+	Body bool
+	// Header is the header that carries the signature, "Authorization" if empty.
+	Header string
+	// Scheme is the authentication scheme, "HMAC" if empty; it is only used
+	// with the Authorization header.
+	Scheme string
+
+	// now returns the signing time; it can be overridden in tests.
+	now func() time.Time
+}
+
+// Sign signs the request by adding the signature header.
+func (s *HMACSigner) Sign(request *http.Request) error {
+	headers := s.Headers
+	if s.Timestamp != "" {
+		now := time.Now
+		if s.now != nil {
+			now = s.now
+		}
+		request.Header.Set(s.Timestamp, strconv.FormatInt(now().Unix(), 10))
+		headers = append(append([]string{}, headers...), s.Timestamp)
+	}
+
+	canonical, err := s.Canonical(request, headers)
+	if err != nil {
+		return err
+	}
+	newHash := s.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	mac := hmac.New(newHash, s.Key)
+	mac.Write([]byte(canonical))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	header := s.Header
+	if header == "" {
+		header = "Authorization"
+	}
+	if !strings.EqualFold(header, "Authorization") {
+		request.Header.Set(header, signature)
+		return nil
+	}
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "HMAC"
+	}
+	names := make([]string, 0, len(headers))
+	for _, name := range headers {
+		names = append(names, strings.ToLower(name))
+	}
+	request.Header.Set(header, fmt.Sprintf(`%s keyId=%q, headers=%q, signature=%q`, scheme, s.KeyID, strings.Join(names, ";"), signature))
+	return nil
+}
+
+// Canonical returns the canonical form of the request that is signed, covering
+// the given headers; it is exported so that servers can verify signatures.
+func (s *HMACSigner) Canonical(request *http.Request, headers []string) (string, error) {
+	lines := []string{request.Method, request.URL.EscapedPath()}
+	switch s.Query {
+	case SortedQuery:
+		lines = append(lines, awsCanonicalQuery(request.URL))
+	case RawQuery:
+		lines = append(lines, request.URL.RawQuery)
+	}
+	for _, name := range headers {
+		var values []string
+		if strings.EqualFold(name, "Host") {
+			host := request.Host
+			if host == "" {
+				host = request.URL.Host
+			}
+			values = []string{host}
+		} else {
+			values = request.Header.Values(name)
+		}
+		if len(values) == 0 {
+			return "", fmt.Errorf("missing header %q", name)
+		}
+		trimmed := make([]string, 0, len(values))
+		for _, value := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+		}
+		lines = append(lines, strings.ToLower(name)+":"+strings.Join(trimmed, ","))
+	}
+	if s.Body {
+		digest, err := BodyDigest(request, sha256.New())
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, hex.EncodeToString(digest))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACSigner(t *testing.T) {
+	signer := &HMACSigner{
+		KeyID:     "key-1",
+		Key:       []byte("secret"),
+		Headers:   []string{"Host", "Content-Type", "X-Multi"},
+		Timestamp: "X-Timestamp",
+		Body:      true,
+		now:       func() time.Time { return time.Unix(1600000000, 0) },
+	}
+	req, err := New("https://api.example.com/a%2Fb/c?z=1&a=2&a=1&sp=x%20y").
+		Post().
+		Add().
+		Header("X-Multi", "  one   two ").
+		Header("X-Multi", "three").
+		WithJSONEntity(map[string]int{"n": 1}).
+		Sign(signer).
+		Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("X-Timestamp") != "1600000000" {
+		t.Fatalf("invalid timestamp: got %q", req.Header.Get("X-Timestamp"))
+	}
+
+	canonical := strings.Join([]string{
+		"POST",
+		"/a%2Fb/c",
+		"a=1&a=2&sp=x%20y&z=1",
+		"host:api.example.com",
+		"content-type:application/json",
+		"x-multi:one two,three",
+		"x-timestamp:1600000000",
+		"2bfd14f43d17fc7cea24e0917a8879b4b2f880b8baeec1b9d90fbaad655e71bd",
+	}, "\n")
+	actual, err := signer.Canonical(req, []string{"Host", "Content-Type", "X-Multi", "X-Timestamp"})
+	if err != nil {
+		t.Fatalf("error computing canonical form: %v", err)
+	}
+	if actual != canonical {
+		t.Fatalf("invalid canonical form:\nexpected %q\ngot      %q", canonical, actual)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(canonical))
+	expected := `HMAC keyId="key-1", headers="host;content-type;x-multi;x-timestamp", signature="` + base64.StdEncoding.EncodeToString(mac.Sum(nil)) + `"`
+	if req.Header.Get("Authorization") != expected {
+		t.Fatalf("invalid authorization:\nexpected %q\ngot      %q", expected, req.Header.Get("Authorization"))
+	}
+	if len(signer.Headers) != 3 {
+		t.Fatalf("signer headers should not be modified: got %v", signer.Headers)
+	}
+}
+
+func TestHMACSignerOptions(t *testing.T) {
+	tests := []struct {
+		query     QueryCanonicalization
+		canonical string
+	}{
+		{SortedQuery, "GET\n/path\na=1&a-b=0&b=2&id=3&id2=4"},
+		{RawQuery, "GET\n/path\nb=2&a=1&id2=4&id=3&a-b=0"},
+		{NoQuery, "GET\n/path"},
+	}
+	for _, test := range tests {
+		signer := &HMACSigner{Key: []byte("secret"), Hash: sha512.New, Query: test.query, Header: "X-Signature"}
+		// the builder sorts query parameters, so the raw query is set by hand
+		req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/path?b=2&a=1&id2=4&id=3&a-b=0", nil)
+		if err := signer.Sign(req); err != nil {
+			t.Fatalf("error signing request: %v", err)
+		}
+		mac := hmac.New(sha512.New, []byte("secret"))
+		mac.Write([]byte(test.canonical))
+		if expected := base64.StdEncoding.EncodeToString(mac.Sum(nil)); req.Header.Get("X-Signature") != expected {
+			t.Fatalf("invalid signature for canonicalization %d: expected %q, got %q", test.query, expected, req.Header.Get("X-Signature"))
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Fatalf("unexpected authorization header: %q", req.Header.Get("Authorization"))
+		}
+	}
+
+	var signatureErr *SignatureError
+	if _, err := New("https://api.example.com").Sign(&HMACSigner{Headers: []string{"Date"}}).Make(); !errors.As(err, &signatureErr) {
+		t.Fatalf("expected signature error on missing header, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MessageSigner signs requests with HTTP Message Signatures (RFC 9421), adding
+// the Signature-Input and Signature headers; if the "content-digest" component
+// is covered and the request has no Content-Digest header, one is computed
+// with SHA-256 as per RFC 9530.
+type MessageSigner struct {
+	// Label is the signature label, "sig1" if empty.
+	Label string
+	// KeyID is the identifier of the key, sent in the "keyid" parameter.
+	KeyID string
+	// Key is the signing key: a []byte for "hmac-sha256", an ed25519.PrivateKey
+	// for "ed25519", an *ecdsa.PrivateKey on P-256 for "ecdsa-p256-sha256" or an
+	// *rsa.PrivateKey for "rsa-pss-sha512" and "rsa-v1_5-sha256".
+	Key interface{}
+	// Algorithm is the signature algorithm; if empty, it is inferred from the
+	// key (RSA keys default to "rsa-pss-sha512") and the "alg" parameter is not
+	// sent.
+	Algorithm string
+	// Components is the list of covered components, either derived components
+	// (e.g. "@method", "@target-uri", "@authority", "@path", "@query") or
+	// lowercase header names; if empty, "@method", "@target-uri" and, if the
+	// request has a body, "content-digest" are covered.
+	Components []string
+	// Expires, if positive, is the validity of the signature.
+	Expires time.Duration
+	// Nonce and Tag are optional signature parameters.
+	Nonce string
+	Tag   string
+
+	// now returns the signing time; it can be overridden in tests.
+	now func() time.Time
+}
+
+// Sign signs the request by adding the Signature-Input and Signature headers.
+func (s *MessageSigner) Sign(request *http.Request) error {
+	algorithm, err := s.algorithm()
+	if err != nil {
+		return err
+	}
+
+	components := s.Components
+	if len(components) == 0 {
+		components = []string{"@method", "@target-uri"}
+		if request.Body != nil && request.Body != http.NoBody {
+			components = append(components, "content-digest")
+		}
+	}
+	for _, component := range components {
+		if component == "content-digest" && request.Header.Get("Content-Digest") == "" {
+			digest, err := BodyDigest(request, sha256.New())
+			if err != nil {
+				return err
+			}
+			request.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest)+":")
+		}
+	}
+
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	params := s.parameters(components, now())
+	base, err := signatureBase(request, components, params)
+	if err != nil {
+		return err
+	}
+	signature, err := s.sign(algorithm, []byte(base))
+	if err != nil {
+		return err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	request.Header.Add("Signature-Input", label+"="+params)
+	request.Header.Add("Signature", label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// algorithm returns the signature algorithm, inferring it from the key type if
+// not explicitly set.
+func (s *MessageSigner) algorithm() (string, error) {
+	if s.Algorithm != "" {
+		return s.Algorithm, nil
+	}
+	switch key := s.Key.(type) {
+	case []byte:
+		return "hmac-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519", nil
+	case *ecdsa.PrivateKey:
+		if key.Curve == elliptic.P256() {
+			return "ecdsa-p256-sha256", nil
+		}
+	case *rsa.PrivateKey:
+		return "rsa-pss-sha512", nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", s.Key)
+}
+
+// parameters returns the inner list of covered components with the signature
+// parameters, as it appears both in the Signature-Input header and in the
+// "@signature-params" line of the signature base.
+func (s *MessageSigner) parameters(components []string, now time.Time) string {
+	quoted := make([]string, 0, len(components))
+	for _, component := range components {
+		quoted = append(quoted, strconv.Quote(component))
+	}
+	params := "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(now.Unix(), 10)
+	if s.Expires > 0 {
+		params += ";expires=" + strconv.FormatInt(now.Add(s.Expires).Unix(), 10)
+	}
+	if s.Nonce != "" {
+		params += ";nonce=" + strconv.Quote(s.Nonce)
+	}
+	if s.Algorithm != "" {
+		params += ";alg=" + strconv.Quote(s.Algorithm)
+	}
+	if s.KeyID != "" {
+		params += ";keyid=" + strconv.Quote(s.KeyID)
+	}
+	if s.Tag != "" {
+		params += ";tag=" + strconv.Quote(s.Tag)
+	}
+	return params
+}
+
+// sign signs the signature base with the given algorithm.
+func (s *MessageSigner) sign(algorithm string, base []byte) ([]byte, error) {
+	switch algorithm {
+	case "hmac-sha256":
+		if key, ok := s.Key.([]byte); ok {
+			return hmacSHA256(key, base), nil
+		}
+	case "ed25519":
+		if key, ok := s.Key.(ed25519.PrivateKey); ok {
+			return ed25519.Sign(key, base), nil
+		}
+	case "ecdsa-p256-sha256":
+		if key, ok := s.Key.(*ecdsa.PrivateKey); ok {
+			digest := sha256.Sum256(base)
+			r, ss, err := ecdsa.Sign(rand.Reader, key, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			// r and s are concatenated as 32-byte big-endian integers
+			signature := make([]byte, 64)
+			r.FillBytes(signature[:32])
+			ss.FillBytes(signature[32:])
+			return signature, nil
+		}
+	case "rsa-pss-sha512":
+		if key, ok := s.Key.(*rsa.PrivateKey); ok {
+			digest := sha512.Sum512(base)
+			return rsa.SignPSS(rand.Reader, key, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+		}
+	case "rsa-v1_5-sha256":
+		if key, ok := s.Key.(*rsa.PrivateKey); ok {
+			digest := sha256.Sum256(base)
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	return nil, fmt.Errorf("invalid key of type %T for signature algorithm %q", s.Key, algorithm)
+}
+
+// signatureBase returns the signature base for the given covered components and
+// signature parameters, as per RFC 9421, section 2.5.
+func signatureBase(request *http.Request, components []string, params string) (string, error) {
+	var buffer strings.Builder
+	for _, component := range components {
+		value, err := componentValue(request, component)
+		if err != nil {
+			return "", err
+		}
+		buffer.WriteString(strconv.Quote(component) + ": " + value + "\n")
+	}
+	buffer.WriteString(`"@signature-params": ` + params)
+	return buffer.String(), nil
+}
+
+// componentValue returns the value of a covered component.
+func componentValue(request *http.Request, component string) (string, error) {
+	switch component {
+	case "@method":
+		return request.Method, nil
+	case "@target-uri":
+		return request.URL.String(), nil
+	case "@authority":
+		host := request.Host
+		if host == "" {
+			host = request.URL.Host
+		}
+		return strings.ToLower(host), nil
+	case "@scheme":
+		return strings.ToLower(request.URL.Scheme), nil
+	case "@request-target":
+		return request.URL.RequestURI(), nil
+	case "@path":
+		if path := request.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + request.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") {
+		return "", fmt.Errorf("unsupported derived component %q", component)
+	}
+	values := request.Header.Values(component)
+	if len(values) == 0 {
+		return "", fmt.Errorf("missing header %q", component)
+	}
+	trimmed := make([]string, 0, len(values))
+	for _, value := range values {
+		trimmed = append(trimmed, strings.TrimSpace(value))
+	}
+	return strings.Join(trimmed, ", "), nil
+}
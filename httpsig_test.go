@@ -0,0 +1,170 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCreated() time.Time {
+	return time.Unix(1618884473, 0)
+}
+
+// TestMessageSignerHMAC uses the HMAC-SHA256 example from RFC 9421, B.2.5.
+func TestMessageSignerHMAC(t *testing.T) {
+	key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	signer := &MessageSigner{
+		Label:      "sig-b25",
+		KeyID:      "test-shared-secret",
+		Key:        key,
+		Components: []string{"date", "@authority", "content-type"},
+		now:        testCreated,
+	}
+
+	req, err := New("https://example.com/foo?param=Value&Pet=dog").
+		Post().
+		Set().
+		Header("Date", "Tue, 20 Apr 2021 02:07:55 GMT").
+		WithEntityAs("application/json", map[string]string{"hello": "world"}).
+		Sign(signer).
+		Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+
+	expected := `sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`
+	if actual := req.Header.Get("Signature-Input"); actual != expected {
+		t.Fatalf("invalid signature input:\nexpected %q\ngot      %q", expected, actual)
+	}
+	expected = "sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:"
+	if actual := req.Header.Get("Signature"); actual != expected {
+		t.Fatalf("invalid signature:\nexpected %q\ngot      %q", expected, actual)
+	}
+}
+
+func TestMessageSignerAsymmetric(t *testing.T) {
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		algorithm string
+		key       interface{}
+		verify    func(base, signature []byte) bool
+	}{
+		{"ed25519", ed25519Key, func(base, signature []byte) bool {
+			return ed25519.Verify(ed25519Key.Public().(ed25519.PublicKey), base, signature)
+		}},
+		{"ecdsa-p256-sha256", ecdsaKey, func(base, signature []byte) bool {
+			digest := sha256.Sum256(base)
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			return ecdsa.Verify(&ecdsaKey.PublicKey, digest[:], r, s)
+		}},
+		{"rsa-pss-sha512", rsaKey, func(base, signature []byte) bool {
+			digest := sha512.Sum512(base)
+			return rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA512, digest[:], signature, nil) == nil
+		}},
+	}
+	for _, test := range tests {
+		signer := &MessageSigner{KeyID: "test-key", Key: test.key, now: testCreated}
+		req, err := New("https://example.com/foo?param=Value&Pet=dog").
+			Post().
+			WithJSONEntity(map[string]string{"hello": "world"}).
+			Sign(signer).
+			Make()
+		if err != nil {
+			t.Fatalf("%s: error making request: %v", test.algorithm, err)
+		}
+
+		// the body digest is computed without consuming the body
+		if req.Header.Get("Content-Digest") != "sha-256=:k6I5cakU5erL8KjSUVTNownDwccvu5kU1Hxg88toFYg=:" {
+			t.Fatalf("%s: invalid content digest: got %q", test.algorithm, req.Header.Get("Content-Digest"))
+		}
+		data := readEntity(t, New("https://example.com").Post().WithJSONEntity(map[string]string{"hello": "world"}).Sign(signer))
+		if string(data) != `{"hello":"world"}` {
+			t.Fatalf("%s: signing should not consume the body: got %q", test.algorithm, string(data))
+		}
+
+		params := strings.TrimPrefix(req.Header.Get("Signature-Input"), "sig1=")
+		if params != `("@method" "@target-uri" "content-digest");created=1618884473;keyid="test-key"` {
+			t.Fatalf("%s: invalid signature input: got %q", test.algorithm, params)
+		}
+		base, err := signatureBase(req, []string{"@method", "@target-uri", "content-digest"}, params)
+		if err != nil {
+			t.Fatalf("%s: error computing signature base: %v", test.algorithm, err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(strings.Trim(strings.TrimPrefix(req.Header.Get("Signature"), "sig1="), ":"))
+		if err != nil {
+			t.Fatalf("%s: invalid signature encoding: %v", test.algorithm, err)
+		}
+		if !test.verify([]byte(base), signature) {
+			t.Fatalf("%s: signature verification failed", test.algorithm)
+		}
+	}
+}
+
+func TestSignatureBase(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://Example.com/foo?param=Value&Pet=dog", nil)
+	req.Header.Add("X-Multi", " a ")
+	req.Header.Add("X-Multi", "b")
+	components := []string{"@method", "@authority", "@scheme", "@path", "@query", "@request-target", "@target-uri", "x-multi"}
+	base, err := signatureBase(req, components, "()")
+	if err != nil {
+		t.Fatalf("error computing signature base: %v", err)
+	}
+	expected := strings.Join([]string{
+		`"@method": POST`,
+		`"@authority": example.com`,
+		`"@scheme": https`,
+		`"@path": /foo`,
+		`"@query": ?param=Value&Pet=dog`,
+		`"@request-target": /foo?param=Value&Pet=dog`,
+		`"@target-uri": https://Example.com/foo?param=Value&Pet=dog`,
+		`"x-multi": a, b`,
+		`"@signature-params": ()`,
+	}, "\n")
+	if base != expected {
+		t.Fatalf("invalid signature base:\nexpected %q\ngot      %q", expected, base)
+	}
+
+	if _, err := signatureBase(req, []string{"x-missing"}, "()"); err == nil {
+		t.Fatalf("expected error on missing header, got none")
+	}
+}
+
+func TestMessageSignerErrors(t *testing.T) {
+	var signatureErr *SignatureError
+	if _, err := New("https://example.com").Sign(&MessageSigner{Key: "not a key"}).Make(); !errors.As(err, &signatureErr) {
+		t.Fatalf("expected signature error on invalid key, got %v", err)
+	}
+	signer := &MessageSigner{Key: []byte("secret"), Components: []string{"content-digest"}}
+	if _, err := New("https://example.com").Post().WithEntity(strings.NewReader("x")).Sign(signer).Make(); err != nil {
+		t.Fatalf("error signing replayable body: %v", err)
+	}
+	if _, err := New("https://example.com").Post().WithEntity(&oneShotReader{}).Sign(signer).Make(); !errors.Is(err, ErrBodyNotReplayable) {
+		t.Fatalf("expected error signing non-replayable body, got %v", err)
+	}
+}
+
+// oneShotReader is an io.Reader that cannot be replayed.
+type oneShotReader struct{}
+
+func (*oneShotReader) Read([]byte) (int, error) {
+	return 0, errors.New("not expected to be read")
+}
@@ -0,0 +1,353 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// timeFormats maps the names that can be used in the "format" tag option to
+// the corresponding time layouts; any other value is used as a layout.
+var timeFormats = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     http.TimeFormat,
+	"http":        http.TimeFormat,
+	"date":        "2006-01-02",
+	"time":        "15:04:05",
+}
+
+// literal is a value that is used as it is, such as the default value of a
+// field given in its tag, which is already in its final form.
+type literal string
+
+// marshalValue converts the value of a tagged field into the strings that are
+// set as query parameters, headers, variables or form fields; its behaviour is
+// the following:
+//   - literals (e.g. default values) are used as they are, regardless of the
+//     "format" option
+//   - nil pointers and interfaces produce no value, other pointers are
+//     dereferenced
+//   - time.Time values are formatted according to the "format" option, which
+//     can be a layout, one of "rfc3339" (the default), "rfc3339nano", "rfc1123"
+//     (or "http"), "date" and "time", or "unix" and "unixmilli" for the number
+//     of seconds or milliseconds since the epoch
+//   - types implementing encoding.TextMarshaler or fmt.Stringer (even with a
+//     pointer receiver) are converted via MarshalText() and String()
+//     respectively
+//   - slices and arrays (other than []byte) produce one value per element, or
+//     a single value if a delimiter is selected via the "comma", "space" or
+//     "pipe" options
+//   - a fmt verb in the "format" option (e.g. "%.2f" or "%08d") is applied to
+//     any other value
+//   - booleans, integers (in the base selected by the "format" option, if any)
+//     and floating point numbers are formatted with the strconv package,
+//     []byte as a string, any other value with fmt.
+func marshalValue(value interface{}, tag Tag) ([]string, error) {
+	if l, ok := value.(literal); ok {
+		return []string{string(l)}, nil
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 && !implementsMarshaler(v) {
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elements, err := marshalValue(v.Index(i).Interface(), tag)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elements...)
+		}
+		if delimiter := tag.Delimiter(); delimiter != "" {
+			return []string{strings.Join(values, delimiter)}, nil
+		}
+		return values, nil
+	}
+
+	s, err := marshalScalar(v, tag)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// marshalScalar converts a single, non-pointer value into a string.
+func marshalScalar(v reflect.Value, tag Tag) (string, error) {
+	if v.Type() == timeType {
+		return formatTime(v.Interface().(time.Time), tag), nil
+	}
+	format, _ := tag.Option("format")
+	if strings.HasPrefix(format, "%") {
+		return fmt.Sprintf(format, v.Interface()), nil
+	}
+
+	// look for marshalers on both the value and a pointer to it, so that methods
+	// with pointer receivers are found too
+	if m, ok := implementer(v, textMarshalerType); ok {
+		text, err := m.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	if m, ok := implementer(v, stringerType); ok {
+		return m.Interface().(fmt.Stringer).String(), nil
+	}
+
+	base := 10
+	if format != "" {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			var err error
+			if base, err = integerBase(format); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("unsupported format %q for values of type %s", format, v.Type())
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), base), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), base), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return string(b), nil
+		}
+	}
+	return fmt.Sprintf("%v", v.Interface()), nil
+}
+
+// integerBase returns the base selected by the "format" option for integers.
+func integerBase(format string) (int, error) {
+	switch format {
+	case "binary":
+		return 2, nil
+	case "octal":
+		return 8, nil
+	case "hex":
+		return 16, nil
+	}
+	if strings.HasPrefix(format, "base") {
+		if base, err := strconv.Atoi(format[4:]); err == nil && base >= 2 && base <= 36 {
+			return base, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported format %q for integers: must be \"binary\", \"octal\", \"hex\", \"base2\" to \"base36\" or a fmt verb", format)
+}
+
+// implementer returns the value if it implements the given interface, or else
+// a pointer to a copy of it if the pointer does; the copy is only allocated if
+// needed, since this is called for each value.
+func implementer(v reflect.Value, iface reflect.Type) (reflect.Value, bool) {
+	if v.Type().Implements(iface) {
+		return v, true
+	}
+	if reflect.PtrTo(v.Type()).Implements(iface) {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p, true
+	}
+	return reflect.Value{}, false
+}
+
+// implementsMarshaler returns whether the value, or a pointer to it, implements
+// encoding.TextMarshaler or fmt.Stringer.
+func implementsMarshaler(v reflect.Value) bool {
+	for _, t := range []reflect.Type{v.Type(), reflect.PtrTo(v.Type())} {
+		if t.Implements(textMarshalerType) || t.Implements(stringerType) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTime formats the time according to the "format" tag option.
+func formatTime(t time.Time, tag Tag) string {
+	format, _ := tag.Option("format")
+	switch format {
+	case "":
+		return t.Format(time.RFC3339)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixmilli":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	if layout, ok := timeFormats[format]; ok {
+		if layout == http.TimeFormat {
+			t = t.UTC()
+		}
+		return t.Format(layout)
+	}
+	return t.Format(format)
+}
+
+// marshalField converts the value of a tagged field into strings and adds them
+// to the result under the given key; if a style is selected, either via the
+// "style" tag option or by an enclosing field, nested structs and maps are
+// flattened according to it (see flatten()).
+func marshalField(name, key string, value interface{}, tag Tag, style string, result map[string][]string) error {
+	if s, ok := tag.Option("style"); ok {
+		style = strings.ToLower(s)
+	} else if _, ok := tag.Option("explode"); ok && style == "" {
+		style = "form"
+	}
+	if style != "" {
+		children, ok, err := nestedValues(name, value, tag)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return flatten(name, key, children, tag, style, result)
+		}
+	}
+	values, err := marshalValue(value, tag)
+	if err != nil {
+		return &FieldError{Tag: name, Name: key, Err: err}
+	}
+	if style == "bracket" && len(values) > 0 && tag.Delimiter() == "" && isList(value) {
+		key += "[]"
+	}
+	if _, ok := result[key]; !ok {
+		result[key] = []string{}
+	}
+	result[key] = append(result[key], values...)
+	return nil
+}
+
+// flatten adds the values of the children of a nested struct or map to the
+// result, with keys built according to the style:
+//   - "bracket": filter[status]=open, with lists as filter[tags][]=a
+//   - "deepobject" (as in OpenAPI): filter[status]=open, with lists as
+//     repeated keys filter[tags]=a
+//   - "dot": filter.status=open
+//   - "form" (as in OpenAPI): status=open if exploded (the default), or
+//     filter=status,open if the "explode=false" option is given.
+//
+// The style applies recursively to structs and maps nested at any depth.
+func flatten(name, key string, children []taggedChild, tag Tag, style string, result map[string][]string) error {
+	switch style {
+	case "bracket", "deepobject", "dot":
+		for _, child := range children {
+			childKey := key + "[" + child.key + "]"
+			if style == "dot" {
+				childKey = key + "." + child.key
+			}
+			if err := marshalField(name, childKey, child.value, child.tag, style, result); err != nil {
+				return err
+			}
+		}
+	case "form":
+		if explode, ok := tag.Option("explode"); ok && explode == "false" {
+			pairs := []string{}
+			for _, child := range children {
+				values, err := marshalValue(child.value, child.tag)
+				if err != nil {
+					return &FieldError{Tag: name, Name: key + "." + child.key, Err: err}
+				}
+				if len(values) > 0 {
+					pairs = append(pairs, child.key, strings.Join(values, ","))
+				}
+			}
+			if len(pairs) > 0 {
+				result[key] = append(result[key], strings.Join(pairs, ","))
+			}
+			return nil
+		}
+		for _, child := range children {
+			if err := marshalField(name, child.key, child.value, child.tag, style, result); err != nil {
+				return err
+			}
+		}
+	default:
+		return &FieldError{Tag: name, Name: key, Err: fmt.Errorf("unsupported style %q", style)}
+	}
+	return nil
+}
+
+// taggedChild is a field of a nested struct, or an entry of a nested map.
+type taggedChild struct {
+	key   string
+	value interface{}
+	tag   Tag
+}
+
+// nestedValues returns the children of the value, sorted by key, if it is a
+// struct (other than time.Time and types with marshalers) or a map; the fields
+// of structs are extracted by scan(), while the entries of maps inherit the tag
+// of the map.
+func nestedValues(name string, value interface{}, tag Tag) ([]taggedChild, bool, error) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false, nil
+		}
+		v = v.Elem()
+	}
+	var children []taggedChild
+	switch {
+	case v.Kind() == reflect.Struct && v.Type() != timeType && !implementsMarshaler(v):
+		scanned, err := scan(name, v.Interface())
+		if err != nil {
+			return nil, false, err
+		}
+		for key, values := range scanned {
+			for _, value := range values {
+				children = append(children, taggedChild{key: key, value: value.value, tag: value.tag})
+			}
+		}
+	case v.Kind() == reflect.Map:
+		for _, key := range v.MapKeys() {
+			children = append(children, taggedChild{key: fmt.Sprintf("%v", key.Interface()), value: v.MapIndex(key).Interface(), tag: tag})
+		}
+	default:
+		return nil, false, nil
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].key < children[j].key
+	})
+	return children, true, nil
+}
+
+// isList returns whether the value is a slice or an array, other than []byte,
+// that is expanded into multiple values.
+func isList(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	return (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 && !implementsMarshaler(v)
+}
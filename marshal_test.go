@@ -0,0 +1,312 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type level int
+
+func (l *level) String() string {
+	return [...]string{"low", "medium", "high"}[*l]
+}
+
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalText() ([]byte, error) {
+	return nil, errors.New("cannot marshal")
+}
+
+func TestMarshalValues(t *testing.T) {
+	from := time.Date(2021, 4, 20, 2, 7, 55, 0, time.FixedZone("CEST", 2*60*60))
+	tags := []string{"a", "b"}
+	s := struct {
+		From      time.Time     `parameter:"from"`
+		To        *time.Time    `parameter:"to,format=unix"`
+		Day       time.Time     `parameter:"day,format=date"`
+		Modified  time.Time     `parameter:"modified,format=rfc1123"`
+		Custom    time.Time     `parameter:"custom,format=15h04"`
+		Tags      []string      `parameter:"tag"`
+		Comma     []string      `parameter:"comma,comma"`
+		Space     [2]int        `parameter:"space,space"`
+		Pipe      []bool        `parameter:"pipe,pipe"`
+		Empty     []string      `parameter:"empty,comma,omitempty"`
+		Pointers  []*string     `parameter:"pointers,comma"`
+		Flag      bool          `parameter:"flag"`
+		Ratio     float64       `parameter:"ratio"`
+		Big       float64       `parameter:"big"`
+		Unsigned  uint8         `parameter:"unsigned"`
+		Level     level         `parameter:"level"`
+		Levels    []level       `parameter:"levels,comma"`
+		IP        net.IP        `parameter:"ip"`
+		Timeout   time.Duration `parameter:"timeout"`
+		Raw       []byte        `parameter:"raw"`
+		Interface interface{}   `parameter:"interface"`
+	}{
+		From:      from,
+		To:        &from,
+		Day:       from,
+		Modified:  from,
+		Custom:    from,
+		Tags:      tags,
+		Comma:     tags,
+		Space:     [2]int{1, 2},
+		Pipe:      []bool{true, false},
+		Pointers:  []*string{&tags[0], nil, &tags[1]},
+		Flag:      true,
+		Ratio:     0.25,
+		Big:       1e21,
+		Unsigned:  255,
+		Level:     2,
+		Levels:    []level{0, 1},
+		IP:        net.IPv4(10, 0, 0, 1),
+		Timeout:   1500 * time.Millisecond,
+		Raw:       []byte("raw"),
+		Interface: 42,
+	}
+
+	m, err := getValuesFrom("parameter", &s)
+	if err != nil {
+		t.Fatalf("error getting values: %v", err)
+	}
+	expected := map[string]string{
+		"from":      "2021-04-20T02:07:55+02:00",
+		"to":        "1618877275",
+		"day":       "2021-04-20",
+		"modified":  "Tue, 20 Apr 2021 00:07:55 GMT",
+		"custom":    "02h07",
+		"tag":       "a;b",
+		"comma":     "a,b",
+		"space":     "1 2",
+		"pipe":      "true|false",
+		"pointers":  "a,b",
+		"flag":      "true",
+		"ratio":     "0.25",
+		"big":       "1000000000000000000000",
+		"unsigned":  "255",
+		"level":     "high",
+		"levels":    "low,medium",
+		"ip":        "10.0.0.1",
+		"timeout":   "1.5s",
+		"raw":       "raw",
+		"interface": "42",
+	}
+	if len(m) != len(expected) {
+		t.Fatalf("invalid number of values: expected %d, got %d (%v)", len(expected), len(m), m)
+	}
+	for key, value := range expected {
+		if actual := strings.Join(m[key], ";"); actual != value {
+			t.Fatalf("invalid value for %q: expected %q, got %q", key, value, actual)
+		}
+	}
+
+	req, err := New("https://www.example.com/").Add().HeadersFrom(&struct {
+		Since  time.Time `header:"If-Modified-Since,format=http"`
+		Accept []string  `header:"Accept,comma"`
+	}{from, []string{"text/html", "application/json"}}).Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("If-Modified-Since") != "Tue, 20 Apr 2021 00:07:55 GMT" || req.Header.Get("Accept") != "text/html,application/json" {
+		t.Fatalf("invalid headers: got %v", req.Header)
+	}
+
+	var fieldErr *FieldError
+	err = New("https://www.example.com/").QueryParametersFrom(struct {
+		Value failingMarshaler `parameter:"value"`
+	}{}).Err()
+	if !errors.As(err, &fieldErr) || fieldErr.Name != "value" {
+		t.Fatalf("expected field error, got %v", err)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	type Owner struct {
+		Name  string `parameter:"name"`
+		Teams []int  `parameter:"team"`
+	}
+	type Filter struct {
+		Status string   `parameter:"status"`
+		Tags   []string `parameter:"tags"`
+		Owner  *Owner   `parameter:"owner"`
+		Hidden string   `parameter:"hidden,omitempty"`
+	}
+	filter := Filter{Status: "open", Tags: []string{"a", "b"}, Owner: &Owner{Name: "me", Teams: []int{1, 2}}}
+	labels := map[string]string{"env": "prod", "tier": "web"}
+
+	tests := []struct {
+		source   interface{}
+		expected string
+	}{
+		{
+			struct {
+				Filter Filter            `parameter:"filter,style=bracket"`
+				Labels map[string]string `parameter:"labels,style=bracket"`
+			}{filter, labels},
+			"filter[owner][name]=me&filter[owner][team][]=1&filter[owner][team][]=2&filter[status]=open&filter[tags][]=a&filter[tags][]=b&labels[env]=prod&labels[tier]=web",
+		},
+		{
+			struct {
+				Filter *Filter `parameter:"filter,style=deepObject"`
+			}{&filter},
+			"filter[owner][name]=me&filter[owner][team]=1&filter[owner][team]=2&filter[status]=open&filter[tags]=a&filter[tags]=b",
+		},
+		{
+			struct {
+				Filter Filter `parameter:"filter,style=dot"`
+			}{filter},
+			"filter.owner.name=me&filter.owner.team=1&filter.owner.team=2&filter.status=open&filter.tags=a&filter.tags=b",
+		},
+		{
+			struct {
+				Labels map[string]string `parameter:"labels,style=form"`
+				Other  string            `parameter:"other"`
+			}{labels, "x"},
+			"env=prod&other=x&tier=web",
+		},
+		{
+			struct {
+				Labels map[string]string `parameter:"labels,style=form,explode=false"`
+				Empty  map[string]string `parameter:"empty,style=form,explode=false"`
+			}{labels, map[string]string{}},
+			"labels=env,prod,tier,web",
+		},
+		{
+			// nested fields can override the style of the enclosing one
+			struct {
+				Filter struct {
+					Labels map[string]string `parameter:"labels,style=dot"`
+					Since  time.Time         `parameter:"since,format=date"`
+				} `parameter:"filter,style=deepObject"`
+			}{struct {
+				Labels map[string]string `parameter:"labels,style=dot"`
+				Since  time.Time         `parameter:"since,format=date"`
+			}{labels, time.Date(2021, 4, 20, 0, 0, 0, 0, time.UTC)}},
+			"filter[labels].env=prod&filter[labels].tier=web&filter[since]=2021-04-20",
+		},
+	}
+	for _, test := range tests {
+		req, err := New("https://www.example.com/").Add().QueryParametersFrom(test.source).Make()
+		if err != nil {
+			t.Fatalf("error making request: %v", err)
+		}
+		if actual, _ := url.QueryUnescape(req.URL.RawQuery); actual != test.expected {
+			t.Fatalf("invalid query:\nexpected %q\ngot      %q", test.expected, actual)
+		}
+	}
+
+	var tagErr *TagError
+	err := New("https://www.example.com/").QueryParametersFrom(struct {
+		Filter Filter `parameter:"filter,style=matrix"`
+	}{filter}).Err()
+	if !errors.As(err, &tagErr) {
+		t.Fatalf("expected tag error on unsupported style, got %v", err)
+	}
+}
+
+func TestStructTagOptions(t *testing.T) {
+	type Page struct {
+		Number int `parameter:"page,default=1" header:"X-Page,default=1"`
+		Size   int `parameter:"size,default=20,omitempty"`
+	}
+	type Request struct {
+		ID       string            `parameter:",required" header:"X-Id,required" variable:"id,required"`
+		Color    int               `parameter:"color,format=hex"`
+		Mask     uint8             `parameter:"mask,format=binary"`
+		Code     int               `parameter:"code,format=base36"`
+		Price    float64           `parameter:"price,format=%.2f"`
+		Padded   int               `parameter:"padded,format=%04d"`
+		Timeout  time.Duration     `parameter:"timeout,format=%d"`
+		Sort     []string          `parameter:"sort,explode=false"`
+		Fields   []string          `parameter:"fields,delimiter=';'"`
+		Repeated []string          `parameter:"repeated,explode"`
+		Label    string            `parameter:"label,default='a,b'"`
+		Extra    map[string]string `parameter:",inline"`
+		Page     `parameter:",inline"`
+		Ignored  *Page `parameter:",inline"`
+	}
+
+	source := Request{
+		ID:       "x1",
+		Color:    255,
+		Mask:     5,
+		Code:     71,
+		Price:    3.14159,
+		Padded:   7,
+		Timeout:  time.Second,
+		Sort:     []string{"name", "-date"},
+		Fields:   []string{"a", "b"},
+		Repeated: []string{"a", "b"},
+		Extra:    map[string]string{"extra": "yes"},
+	}
+	req, err := New("https://www.example.com/items/{id}").
+		Add().
+		QueryParametersFrom(source).
+		HeadersFrom(source).
+		VariablesFrom(source).
+		Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	query, _ := url.QueryUnescape(req.URL.RawQuery)
+	expected := "ID=x1&code=1z&color=ff&extra=yes&fields=a;b&label=a,b&mask=101&padded=0007&page=1&price=3.14&repeated=a&repeated=b&size=20&sort=name,-date&timeout=1000000000"
+	if req.URL.Path != "/items/x1" || query != expected {
+		t.Fatalf("invalid URL:\nexpected %q\ngot      %q (path %q)", expected, query, req.URL.Path)
+	}
+	if req.Header.Get("X-Id") != "x1" || req.Header.Get("X-Page") != "1" {
+		t.Fatalf("invalid headers: got %v", req.Header)
+	}
+
+	// required fields are checked uniformly by all struct-driven methods
+	for _, f := range []*Builder{
+		New("https://www.example.com/").QueryParametersFrom(Request{}),
+		New("https://www.example.com/").HeadersFrom(&Request{}),
+		New("https://www.example.com/").VariablesFrom(Request{}),
+	} {
+		_, err := f.Make()
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || !errors.Is(err, ErrRequired) {
+			t.Fatalf("expected required field error, got %v", err)
+		}
+	}
+
+	// defaults are sent as they are, without applying the format
+	defaults := struct {
+		Since time.Time  `parameter:"since,default=2020-01-01,format=date"`
+		Color int        `parameter:"color,default=10,format=hex"`
+		Price float64    `parameter:"price,default=1.5,format=%.2f"`
+		Codes []int      `parameter:"codes,default=a,format=hex,comma"`
+		When  *time.Time `header:"X-When,default=now,format=unix"`
+	}{}
+	req, err = New("https://www.example.com/").QueryParametersFrom(defaults).HeadersFrom(defaults).Make()
+	if err != nil {
+		t.Fatalf("error making request with defaults: %v", err)
+	}
+	query, _ = url.QueryUnescape(req.URL.RawQuery)
+	if expected := "codes=a&color=10&price=1.5&since=2020-01-01"; query != expected || req.Header.Get("X-When") != "now" {
+		t.Fatalf("invalid defaults: expected %q, got %q (headers %v)", expected, query, req.Header)
+	}
+
+	var fieldErr *FieldError
+	err = New("https://www.example.com/").QueryParametersFrom(struct {
+		Name string `parameter:"name,format=hex"`
+	}{"x"}).Err()
+	if !errors.As(err, &fieldErr) {
+		t.Fatalf("expected field error on unsupported format, got %v", err)
+	}
+	var tagErr *TagError
+	err = New("https://www.example.com/").HeadersFrom(struct {
+		Name string `header:"X-Name,requird"`
+	}{"x"}).Err()
+	if !errors.As(err, &tagErr) || tagErr.Field != "Name" {
+		t.Fatalf("expected tag error on misspelled option, got %v", err)
+	}
+}
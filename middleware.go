@@ -0,0 +1,30 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"net/http"
+)
+
+// Hook is a function that is applied to a copy of the builder right before a
+// request is made; it can modify the copy freely (e.g. by adding headers or
+// query parameters) and, by returning an error, prevent the request from being
+// made.
+type Hook func(*Builder) error
+
+// Middleware wraps the round trip of each request submitted via Builder.Do();
+// the returned http.RoundTripper should call the next one to proceed with the
+// submission, and can inspect and modify both the request (after cloning it)
+// and the response.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to allow the use of ordinary functions as
+// http.RoundTrippers, e.g. when writing Middlewares.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(request).
+func (f RoundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
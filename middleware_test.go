@@ -0,0 +1,105 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHook(t *testing.T) {
+	counter := 0
+	f := New("https://www.example.com/{id}").
+		Hook(func(b *Builder) error {
+			counter++
+			b.Set().Header("X-Request-Id", fmt.Sprintf("request-%d", counter))
+			return nil
+		}).
+		Hook(func(b *Builder) error {
+			b.Set().Variable("id", counter).Add().QueryParameter("quota", "1")
+			return nil
+		})
+
+	for i := 1; i <= 2; i++ {
+		req, err := f.Make()
+		if err != nil {
+			t.Fatalf("error making request: %v", err)
+		}
+		if req.Header.Get("X-Request-Id") != fmt.Sprintf("request-%d", i) {
+			t.Fatalf("invalid hooked header: got %q", req.Header.Get("X-Request-Id"))
+		}
+		if expected := fmt.Sprintf("https://www.example.com/%d?quota=1", i); req.URL.String() != expected {
+			t.Fatalf("invalid hooked URL: expected %q, got %q", expected, req.URL.String())
+		}
+	}
+
+	// the builder is left untouched
+	if len(f.headers) != 0 || len(f.parameters) != 0 || len(f.variables) != 0 {
+		t.Fatalf("hooks should not modify the builder")
+	}
+
+	// sub-builders inherit hooks
+	req, err := f.New("", "").Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("X-Request-Id") != "request-3" {
+		t.Fatalf("sub-builder should inherit hooks: got %q", req.Header.Get("X-Request-Id"))
+	}
+
+	// hooks can prevent requests from being made
+	expected := errors.New("quota exceeded")
+	if _, err := f.Hook(func(*Builder) error { return expected }).Make(); err != expected {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+}
+
+func TestUse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Trace", r.Header.Get("X-Trace"))
+	}))
+	defer server.Close()
+
+	var trace []string
+	middleware := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				trace = append(trace, "before "+name)
+				req = req.Clone(req.Context())
+				req.Header.Add("X-Trace", name)
+				res, err := next.RoundTrip(req)
+				trace = append(trace, "after "+name)
+				return res, err
+			})
+		}
+	}
+
+	f := New(server.URL).Client(server.Client()).Use(middleware("outer"), middleware("inner"))
+	response, err := f.New("", "").Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.Header.Get("X-Trace") != "outer" {
+		t.Fatalf("invalid trace header: got %q", response.Header.Get("X-Trace"))
+	}
+	if actual := strings.Join(trace, ", "); actual != "before outer, before inner, after inner, after outer" {
+		t.Fatalf("invalid middleware order: got %q", actual)
+	}
+
+	// middlewares can short-circuit the submission
+	f = New(server.URL).Client(server.Client()).Use(func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("blocked")
+		})
+	})
+	if _, err := f.Do(context.Background()); err == nil || err.Error() != "blocked" {
+		t.Fatalf("expected middleware error, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Multipart describes a multipart/form-data entity, made of simple fields and
+// file parts; the contents of the parts are only read when the entity is
+// streamed to the server, see Builder.WithMultipartEntity().
+type Multipart struct {
+
+	// boundary is the multipart boundary.
+	boundary string
+
+	// parts is the ordered list of parts in the multipart form.
+	parts []part
+
+	// err is the first error recorded while preparing the form.
+	err error
+}
+
+// part is a single part of a multipart form, with its own headers.
+type part struct {
+	header  textproto.MIMEHeader
+	content *payload
+}
+
+// NewMultipart returns a new, empty multipart form with a random boundary.
+func NewMultipart() *Multipart {
+	return &Multipart{
+		boundary: multipart.NewWriter(ioutil.Discard).Boundary(),
+	}
+}
+
+// Boundary sets the multipart boundary, overriding the random one; if the
+// boundary is invalid, an error is recorded.
+func (m *Multipart) Boundary(boundary string) *Multipart {
+	if err := multipart.NewWriter(ioutil.Discard).SetBoundary(boundary); err != nil {
+		return m.fail(err)
+	}
+	m.boundary = boundary
+	return m
+}
+
+// Field adds a simple form field with the given values, one part per value.
+func (m *Multipart) Field(name string, values ...string) *Multipart {
+	for _, value := range values {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(name)))
+		m.parts = append(m.parts, part{header: header, content: newPayload(strings.NewReader(value))})
+	}
+	return m
+}
+
+// FieldsFrom adds simple form fields from a struct (whose fields are tagged with
+// "form") or from a map[string][]string; fields are added in key order. If the
+// source is neither a struct nor a map, a *SourceError is recorded.
+func (m *Multipart) FieldsFrom(source interface{}) *Multipart {
+	values, err := getValuesFrom("form", source)
+	if err != nil {
+		return m.fail(err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m.Field(key, values[key]...)
+	}
+	return m
+}
+
+// File adds a file part, whose contents will be streamed from the given reader;
+// if no content type is given, "application/octet-stream" is used. The reader
+// is closed after use if it implements io.Closer; unless it is a *bytes.Buffer,
+// *bytes.Reader or *strings.Reader, it can only be consumed once, so the form
+// will not be replayable (use FileFunc() for replayable file parts).
+func (m *Multipart) File(name, filename, contentType string, content io.Reader) *Multipart {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return m.Part(fileHeader(name, filename, contentType), content)
+}
+
+// FileFunc adds a file part, whose contents will be streamed from a fresh reader
+// returned by the given function each time the form is written, e.g. by re-
+// opening a file; this keeps the form replayable. If no content type is given,
+// "application/octet-stream" is used.
+func (m *Multipart) FileFunc(name, filename, contentType string, open func() (io.ReadCloser, error)) *Multipart {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	m.parts = append(m.parts, part{header: fileHeader(name, filename, contentType), content: newFuncPayload(open, -1)})
+	return m
+}
+
+// Part adds a part with arbitrary headers, whose contents will be streamed from
+// the given reader; the same considerations as in File() apply.
+func (m *Multipart) Part(header textproto.MIMEHeader, content io.Reader) *Multipart {
+	m.parts = append(m.parts, part{header: header, content: newPayload(content)})
+	return m
+}
+
+// ContentType returns the multipart Content-Type, including the boundary.
+func (m *Multipart) ContentType() string {
+	return "multipart/form-data; boundary=" + m.boundary
+}
+
+// Reader returns an io.Reader that streams the multipart form; the parts are
+// written as the reader is consumed.
+func (m *Multipart) Reader() io.Reader {
+	return &multipartReader{multipart: m}
+}
+
+// payload returns a payload factory for the multipart form, which is replayable
+// only if all of its parts are.
+func (m *Multipart) payload() *payload {
+	replayable := true
+	for _, part := range m.parts {
+		replayable = replayable && part.content.replayable
+	}
+	return &payload{
+		open: func() (io.ReadCloser, error) {
+			return &multipartReader{multipart: m}, nil
+		},
+		length:     -1,
+		replayable: replayable,
+	}
+}
+
+// fail records the first error occurred while preparing the form.
+func (m *Multipart) fail(err error) *Multipart {
+	if m.err == nil {
+		m.err = err
+	}
+	return m
+}
+
+// write writes the multipart form to the given writer.
+func (m *Multipart) write(w io.Writer) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+	for _, part := range m.parts {
+		pw, err := writer.CreatePart(part.header)
+		if err != nil {
+			return err
+		}
+		content, err := part.content.open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(pw, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// multipartReader lazily starts writing the multipart form into a pipe the
+// first time it is read.
+type multipartReader struct {
+	multipart *Multipart
+	once      sync.Once
+	pipe      *io.PipeReader
+}
+
+// Read reads from the pipe, starting the writer on the first call.
+func (r *multipartReader) Read(p []byte) (int, error) {
+	r.once.Do(r.start)
+	return r.pipe.Read(p)
+}
+
+// Close closes the reading end of the pipe, so that the writer, if started,
+// can terminate.
+func (r *multipartReader) Close() error {
+	r.once.Do(func() {
+		r.pipe, _ = io.Pipe()
+	})
+	return r.pipe.Close()
+}
+
+// start creates the pipe and launches the writer goroutine.
+func (r *multipartReader) start() {
+	pr, pw := io.Pipe()
+	r.pipe = pr
+	go func() {
+		pw.CloseWithError(r.multipart.write(pw))
+	}()
+}
+
+// fileHeader returns the part headers for a file.
+func fileHeader(name, filename, contentType string) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(name), escapeQuotes(filename)))
+	header.Set("Content-Type", contentType)
+	return header
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// escapeQuotes escapes quotes and backslashes in Content-Disposition values.
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,145 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestWithFormEntity(t *testing.T) {
+	form := struct {
+		Name    string   `form:"name"`
+		Surname string   `form:"surname"`
+		Ignored string   `form:"-"`
+		Other   string   `parameter:"other"`
+		Age     *int     `form:"age,omitempty"`
+		Tags    []string `json:"tags"`
+	}{
+		Name:    "John",
+		Surname: "Doe & Sons",
+		Ignored: "ignored",
+		Other:   "other",
+	}
+
+	f := New("").Post().WithFormEntity(form)
+	data := readEntity(t, f)
+	expected := "name=John&surname=Doe+%26+Sons"
+	if string(data) != expected {
+		t.Fatalf("error adding form entity: expected %q, got %q", expected, string(data))
+	}
+	if f.headers.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		t.Fatalf("error adding form entity: content type is %q", f.headers.Get("Content-Type"))
+	}
+
+	f = New("").Post().WithFormEntity(map[string][]string{"a": {"1", "2"}})
+	data = readEntity(t, f)
+	if string(data) != "a=1&a=2" {
+		t.Fatalf("error adding form entity: expected \"a=1&a=2\", got %q", string(data))
+	}
+
+	if err := New("").WithFormEntity("a string").Err(); err == nil {
+		t.Fatalf("expected error adding string as form entity, got none")
+	}
+}
+
+func TestWithMultipartEntity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("name") != "John" || r.FormValue("surname") != "Doe" {
+			http.Error(w, "invalid fields", http.StatusBadRequest)
+			return
+		}
+		if len(r.MultipartForm.Value["tag"]) != 2 {
+			http.Error(w, "invalid multi-valued field", http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("artifact")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, _ := ioutil.ReadAll(file)
+		if header.Filename != "artifact.txt" || header.Header.Get("Content-Type") != "text/plain" || string(data) != "artifact contents" {
+			http.Error(w, "invalid file", http.StatusBadRequest)
+			return
+		}
+		if r.MultipartForm.File["blob"][0].Header.Get("X-Custom") != "custom" {
+			http.Error(w, "invalid custom part", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="blob"; filename="blob.bin"`)
+	header.Set("X-Custom", "custom")
+
+	multipart := NewMultipart().
+		FieldsFrom(struct {
+			Name    string `form:"name"`
+			Surname string `form:"surname"`
+		}{"John", "Doe"}).
+		Field("tag", "a", "b").
+		File("artifact", "artifact.txt", "text/plain", strings.NewReader("artifact contents")).
+		Part(header, strings.NewReader("\x00\x01\x02"))
+
+	f := New(server.URL).Client(server.Client()).Post().WithMultipartEntity(multipart)
+	if !strings.HasPrefix(f.headers.Get("Content-Type"), "multipart/form-data; boundary=") {
+		t.Fatalf("invalid content type: got %q", f.headers.Get("Content-Type"))
+	}
+	response, err := f.Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting multipart request: %v", err)
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("invalid status code: expected 204, got %d (%s)", response.StatusCode, response.String())
+	}
+
+	if err := New("").WithMultipartEntity(NewMultipart().Boundary("\x00")).Err(); err == nil {
+		t.Fatalf("expected error on invalid boundary, got none")
+	}
+}
+
+func TestMultipartReplayable(t *testing.T) {
+	multipart := NewMultipart().
+		Boundary("boundary").
+		Field("name", "John").
+		FileFunc("artifact", "artifact.txt", "text/plain", func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("artifact contents")), nil
+		})
+
+	f := New("https://www.example.com").Post().WithMultipartEntity(multipart)
+	first := string(readEntity(t, f))
+	second := string(readEntity(t, f.New("", "")))
+	if first == "" || first != second {
+		t.Fatalf("multipart entity should be replayable: got %q and %q", first, second)
+	}
+	if !strings.Contains(first, "artifact contents") || !strings.Contains(first, "--boundary--") {
+		t.Fatalf("invalid multipart entity: got %q", first)
+	}
+	req, _ := f.Make()
+	if req.GetBody == nil {
+		t.Fatalf("multipart request body should be replayable")
+	}
+
+	f = New("https://www.example.com").Post().WithMultipartEntity(NewMultipart().File("artifact", "artifact.txt", "", bufio.NewReader(strings.NewReader("stream"))))
+	req, _ = f.Make()
+	if req.GetBody != nil {
+		t.Fatalf("multipart request body with streamed parts should not be replayable")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2 is a TokenSource that obtains tokens from an OAuth2 authorization
+// server via the client credentials or the refresh token grant (RFC 6749); the
+// token endpoint is called via a Builder with a form entity, tokens are cached
+// until they expire and, since OAuth2 implements Invalidator, they are dropped
+// and refreshed when a resource server responds with 401.
+type OAuth2 struct {
+
+	// endpoint is the builder for requests to the token endpoint.
+	endpoint *Builder
+
+	// grant is the OAuth2 grant type.
+	grant string
+
+	// scopes is the list of requested scopes.
+	scopes []string
+
+	// leeway is how long before its expiry a token is refreshed.
+	leeway time.Duration
+
+	// mutex protects the token and the refresh token.
+	mutex sync.Mutex
+
+	// token is the cached token.
+	token *Token
+
+	// refreshToken is the current refresh token, if any.
+	refreshToken string
+}
+
+// ClientCredentials returns an OAuth2 token source for the client credentials
+// grant; the client authenticates to the token endpoint via HTTP Basic.
+func ClientCredentials(tokenURL, clientID, clientSecret string, scopes ...string) *OAuth2 {
+	return &OAuth2{
+		endpoint: New(tokenURL).Post().BasicAuth(clientID, clientSecret),
+		grant:    "client_credentials",
+		scopes:   scopes,
+		leeway:   10 * time.Second,
+	}
+}
+
+// RefreshToken returns an OAuth2 token source for the refresh token grant,
+// starting from the given refresh token; if the authorization server rotates
+// the refresh token, the new one is used from then on. The client
+// authenticates to the token endpoint via HTTP Basic.
+func RefreshToken(tokenURL, clientID, clientSecret, refreshToken string, scopes ...string) *OAuth2 {
+	return &OAuth2{
+		endpoint:     New(tokenURL).Post().BasicAuth(clientID, clientSecret),
+		grant:        "refresh_token",
+		scopes:       scopes,
+		leeway:       10 * time.Second,
+		refreshToken: refreshToken,
+	}
+}
+
+// Endpoint returns the builder used for requests to the token endpoint, so
+// that it can be customised, e.g. by providing its own http.Client.
+func (o *OAuth2) Endpoint() *Builder {
+	return o.endpoint
+}
+
+// Leeway sets how long before its expiry a token is refreshed (10s by default).
+func (o *OAuth2) Leeway(leeway time.Duration) *OAuth2 {
+	o.leeway = leeway
+	return o
+}
+
+// Token returns the cached token, or obtains a new one from the authorization
+// server if there is none or it is about to expire.
+func (o *OAuth2) Token(ctx context.Context) (*Token, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.token != nil && !o.token.IsExpired(o.leeway) {
+		return o.token, nil
+	}
+
+	form := struct {
+		GrantType    string `form:"grant_type"`
+		RefreshToken string `form:"refresh_token,omitempty"`
+		Scope        string `form:"scope,omitempty"`
+	}{
+		GrantType:    o.grant,
+		RefreshToken: o.refreshToken,
+		Scope:        strings.Join(o.scopes, " "),
+	}
+
+	response, err := o.endpoint.New("", "").
+		Set().
+		Header("Accept", "application/json").
+		WithFormEntity(form).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var success struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	failure := &OAuth2Error{StatusCode: response.StatusCode}
+	if err := response.Into(&success, failure); err != nil {
+		return nil, fmt.Errorf("invalid token endpoint response: %v", err)
+	}
+	if !response.IsSuccess() {
+		return nil, failure
+	}
+	if success.AccessToken == "" {
+		return nil, fmt.Errorf("invalid token endpoint response: no access token")
+	}
+
+	o.token = &Token{
+		Type:  bearerType(success.TokenType),
+		Value: success.AccessToken,
+	}
+	if success.ExpiresIn > 0 {
+		o.token.Expiry = time.Now().Add(time.Duration(success.ExpiresIn) * time.Second)
+	}
+	if success.RefreshToken != "" && o.grant == "refresh_token" {
+		o.refreshToken = success.RefreshToken
+	}
+	return o.token, nil
+}
+
+// Invalidate drops the cached token, so that a new one is obtained next time.
+func (o *OAuth2) Invalidate() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.token = nil
+}
+
+// bearerType normalises the token type, since some authorization servers
+// return "bearer" in lowercase.
+func bearerType(tokenType string) string {
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		return "Bearer"
+	}
+	return tokenType
+}
+
+// OAuth2Error is returned when the authorization server rejects the token
+// request.
+type OAuth2Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	URI         string `json:"error_uri"`
+}
+
+// Error returns the OAuth2Error as a string.
+func (e *OAuth2Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth2 error %q (status %d): %s", e.Code, e.StatusCode, e.Description)
+	}
+	return fmt.Sprintf("oauth2 error %q (status %d)", e.Code, e.StatusCode)
+}
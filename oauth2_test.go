@@ -0,0 +1,158 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// authorizationServer is a toy OAuth2 authorization and resource server.
+type authorizationServer struct {
+	sync.Mutex
+	issued        int
+	refreshTokens []string
+	revoked       map[string]bool
+}
+
+func (s *authorizationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch r.URL.Path {
+	case "/token":
+		w.Header().Set("Content-Type", "application/json")
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad credentials"}`)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response := map[string]interface{}{
+			"token_type": "bearer",
+			"expires_in": 3600,
+		}
+		switch r.PostFormValue("grant_type") {
+		case "client_credentials":
+			if r.PostFormValue("scope") != "read write" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_scope"}`)
+				return
+			}
+		case "refresh_token":
+			s.refreshTokens = append(s.refreshTokens, r.PostFormValue("refresh_token"))
+			response["refresh_token"] = fmt.Sprintf("refresh-%d", len(s.refreshTokens))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"unsupported_grant_type"}`)
+			return
+		}
+		s.issued++
+		response["access_token"] = fmt.Sprintf("token-%d", s.issued)
+		json.NewEncoder(w).Encode(response)
+	case "/resource":
+		token := r.Header.Get("Authorization")
+		if token == "" || s.revoked[token] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, token)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	handler := &authorizationServer{revoked: map[string]bool{}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	source := ClientCredentials(server.URL+"/token", "client", "secret", "read", "write")
+	source.Endpoint().Client(server.Client())
+
+	f := New(server.URL + "/resource").Client(server.Client()).TokenSource(source)
+	for i := 0; i < 3; i++ {
+		response, err := f.Do(context.Background())
+		if err != nil {
+			t.Fatalf("error submitting request: %v", err)
+		}
+		if response.String() != "Bearer token-1" {
+			t.Fatalf("invalid token: expected \"Bearer token-1\", got %q", response.String())
+		}
+	}
+	if handler.issued != 1 {
+		t.Fatalf("tokens should be cached: expected 1 token to be issued, got %d", handler.issued)
+	}
+
+	// revoked tokens are refreshed on 401
+	handler.revoked["Bearer token-1"] = true
+	response, err := f.Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.String() != "Bearer token-2" {
+		t.Fatalf("invalid refreshed token: expected \"Bearer token-2\", got %q (%d)", response.String(), response.StatusCode)
+	}
+
+	// and if the fresh token is rejected too, the 401 is returned
+	handler.revoked["Bearer token-2"] = true
+	handler.revoked["Bearer token-3"] = true
+	response, err = f.Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.StatusCode != http.StatusUnauthorized || handler.issued != 3 {
+		t.Fatalf("invalid outcome: expected 401 after 3 issued tokens, got %d after %d", response.StatusCode, handler.issued)
+	}
+}
+
+func TestClientCredentialsError(t *testing.T) {
+	handler := &authorizationServer{revoked: map[string]bool{}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	source := ClientCredentials(server.URL+"/token", "client", "wrong")
+	source.Endpoint().Client(server.Client())
+
+	_, err := New(server.URL + "/resource").Client(server.Client()).TokenSource(source).Do(context.Background())
+	var oauth2Err *OAuth2Error
+	if !errors.As(err, &oauth2Err) {
+		t.Fatalf("expected OAuth2 error, got %v", err)
+	}
+	if oauth2Err.Code != "invalid_client" || oauth2Err.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("invalid OAuth2 error: got %+v", oauth2Err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	handler := &authorizationServer{revoked: map[string]bool{}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	source := RefreshToken(server.URL+"/token", "client", "secret", "refresh-0")
+	source.Endpoint().Client(server.Client())
+
+	for i := 1; i <= 2; i++ {
+		token, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatalf("error obtaining token: %v", err)
+		}
+		if token.String() != fmt.Sprintf("Bearer token-%d", i) {
+			t.Fatalf("invalid token: got %q", token.String())
+		}
+		source.Invalidate()
+	}
+	// the rotated refresh token is used for the second request
+	if len(handler.refreshTokens) != 2 || handler.refreshTokens[0] != "refresh-0" || handler.refreshTokens[1] != "refresh-1" {
+		t.Fatalf("invalid refresh tokens: got %q", handler.refreshTokens)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// payload is a factory of request bodies: each request generated by a builder
+// opens its own fresh body, so the same builder (and all of its sub-builders)
+// can generate any number of requests with the same payload, and requests can
+// be replayed on redirects and retries via http.Request.GetBody.
+type payload struct {
+
+	// open returns a new reader over the payload.
+	open func() (io.ReadCloser, error)
+
+	// length is the length of the payload, or -1 if unknown.
+	length int64
+
+	// replayable is whether open can be called more than once; payloads built
+	// from arbitrary io.Readers can only be read once.
+	replayable bool
+}
+
+// newPayload returns a payload factory for the given reader; *bytes.Buffer,
+// *bytes.Reader and *strings.Reader are snapshotted and can be replayed, all
+// other readers can only be consumed once. Nil readers, including nil pointers
+// of reader types, make no payload.
+func newPayload(r io.Reader) *payload {
+	if isNilReferenceType(r) {
+		return nil
+	}
+	switch v := r.(type) {
+	case *bytes.Buffer:
+		return newBytesPayload(append([]byte(nil), v.Bytes()...))
+	case *bytes.Reader:
+		snapshot := *v
+		return &payload{
+			open: func() (io.ReadCloser, error) {
+				r := snapshot
+				return ioutil.NopCloser(&r), nil
+			},
+			length:     int64(v.Len()),
+			replayable: true,
+		}
+	case *strings.Reader:
+		snapshot := *v
+		return &payload{
+			open: func() (io.ReadCloser, error) {
+				r := snapshot
+				return ioutil.NopCloser(&r), nil
+			},
+			length:     int64(v.Len()),
+			replayable: true,
+		}
+	}
+	rc, ok := r.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(r)
+	}
+	return &payload{
+		open: func() (io.ReadCloser, error) {
+			return rc, nil
+		},
+		length: -1,
+	}
+}
+
+// newBytesPayload returns a replayable payload over the given data.
+func newBytesPayload(data []byte) *payload {
+	return &payload{
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		},
+		length:     int64(len(data)),
+		replayable: true,
+	}
+}
+
+// newFuncPayload returns a replayable payload whose readers are provided by the
+// given function.
+func newFuncPayload(open func() (io.ReadCloser, error), length int64) *payload {
+	return &payload{
+		open:       open,
+		length:     length,
+		replayable: true,
+	}
+}
+
+// attach opens a fresh body and sets it in the request, along with its length
+// and, if the payload is replayable, the GetBody function.
+func (p *payload) attach(request *http.Request) error {
+	if p.replayable && p.length == 0 {
+		request.Body = http.NoBody
+		request.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return nil
+	}
+	body, err := p.open()
+	if err != nil {
+		return err
+	}
+	request.Body = body
+	if p.length > 0 {
+		request.ContentLength = p.length
+	}
+	if p.replayable {
+		request.GetBody = p.open
+	}
+	return nil
+}
+
+// bytes returns the whole payload, if replayable.
+func (p *payload) bytes() ([]byte, error) {
+	body, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
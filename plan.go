@@ -0,0 +1,117 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/dihedron/go-log"
+)
+
+// structPlan is the compiled form of a struct type for a given set of tag
+// keys: it lists the fields that scanTags() needs to look at, along with their
+// parsed tags, so that reflection on the struct type and tag parsing happen
+// only once per type, and not each time a value of that type is scanned.
+type structPlan struct {
+	// fields are the exported fields that are either tagged with any of the
+	// keys, or untagged but possibly holding a struct to recurse into.
+	fields []fieldPlan
+	// err is the error in the tag of the field that follows the last one in
+	// fields, if any; it is returned once the fields before it are scanned, so
+	// that errors are reported in the same order as fields are met.
+	err *TagError
+}
+
+// fieldPlan describes how a field is scanned.
+type fieldPlan struct {
+	// index is the index of the field in its struct.
+	index int
+	// tags are the tags of the field, in the order of the keys; if there are
+	// none, the field is untagged and is recursed into if it holds a struct.
+	tags []fieldTag
+}
+
+// fieldTag is the parsed tag of a field for one of the keys.
+type fieldTag struct {
+	// key is the tag key (e.g. "parameter").
+	key string
+	// name is the name in the tag or, if it is empty, the name of the field.
+	name string
+	// tag is the parsed tag.
+	tag Tag
+}
+
+// planKey identifies a compiled plan in the cache.
+type planKey struct {
+	typ  reflect.Type
+	keys string
+}
+
+// plans caches the compiled plans by struct type and tag keys; struct types
+// are finite in a program, so the cache is never evicted.
+var plans sync.Map
+
+// planFor returns the compiled plan for the given struct type and tag keys,
+// compiling and caching it on first use.
+func planFor(typ reflect.Type, keys []string) *structPlan {
+	key := planKey{typ: typ}
+	if len(keys) == 1 {
+		key.keys = keys[0]
+	} else {
+		key.keys = strings.Join(keys, ",")
+	}
+	if plan, ok := plans.Load(key); ok {
+		return plan.(*structPlan)
+	}
+	plan, _ := plans.LoadOrStore(key, compilePlan(typ, keys))
+	return plan.(*structPlan)
+}
+
+// compilePlan parses the tags of the exported fields of the struct type; fields
+// that have none of the tags and cannot hold a struct are left out of the plan.
+func compilePlan(typ reflect.Type, keys []string) *structPlan {
+	log.Debugf("compiling plan for type %v and tags %v...", typ, keys)
+	plan := &structPlan{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		f := fieldPlan{index: i}
+		if field.PkgPath != "" {
+			// unexported fields cannot be read, but the exported fields of
+			// embedded structs can, so they are recursed into as if untagged
+			if field.Anonymous && isStructType(field.Type) {
+				plan.fields = append(plan.fields, f)
+			}
+			continue
+		}
+		for _, key := range keys {
+			tag, err := ParseTag(field.Tag.Get(key))
+			if err != nil {
+				plan.err = err.(*TagError)
+				plan.err.Field = field.Name
+				return plan
+			}
+			if tag.IsMissing() {
+				continue
+			}
+			name := tag.Name()
+			if name == "" {
+				name = field.Name
+			}
+			f.tags = append(f.tags, fieldTag{key: key, name: name, tag: tag})
+		}
+		if len(f.tags) == 0 && !isStructType(field.Type) {
+			continue
+		}
+		plan.fields = append(plan.fields, f)
+	}
+	return plan
+}
+
+// isStructType returns whether the type is a struct or a pointer to a struct.
+func isStructType(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Struct || (typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Struct)
+}
@@ -0,0 +1,153 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type benchPaging struct {
+	Page  int `parameter:"page,omitempty"`
+	Limit int `parameter:"limit,default=20"`
+}
+
+type benchQuery struct {
+	Paging  benchPaging
+	Owner   string            `parameter:"owner,required" header:"X-Owner"`
+	Status  []string          `parameter:"status,comma"`
+	Labels  []string          `parameter:"label"`
+	Since   time.Time         `parameter:"since,format=date"`
+	Color   int               `parameter:"color,format=hex"`
+	Tenant  string            `variable:"tenant" header:"X-Tenant"`
+	Trace   *string           `header:"X-Trace-Id,omitempty"`
+	Extra   map[string]string `parameter:",inline"`
+	Ignored string            `parameter:"-"`
+	ignored string            // unexported fields are skipped
+}
+
+func newBenchQuery() *benchQuery {
+	return &benchQuery{
+		Paging: benchPaging{Page: 2},
+		Owner:  "me",
+		Status: []string{"open", "closed"},
+		Labels: []string{"a", "b"},
+		Since:  time.Date(2021, 4, 20, 0, 0, 0, 0, time.UTC),
+		Color:  255,
+		Tenant: "acme",
+		Extra:  map[string]string{"x": "y"},
+	}
+}
+
+func TestPlanFor(t *testing.T) {
+	typ := reflect.TypeOf(benchQuery{})
+	plan := planFor(typ, []string{"parameter"})
+	if planFor(typ, []string{"parameter"}) != plan {
+		t.Fatalf("expected plan to be cached")
+	}
+	if planFor(typ, []string{"parameter", "header"}) == plan {
+		t.Fatalf("expected plans for different tags to be distinct")
+	}
+	// Paging (untagged struct), Owner, Status, Labels, Since, Color, Extra,
+	// Ignored; Tenant and Trace have no parameter tag, ignored is unexported
+	if len(plan.fields) != 8 {
+		t.Fatalf("invalid number of fields in plan: expected 8, got %d", len(plan.fields))
+	}
+
+	// values are scanned concurrently from the same plan
+	expected, err := getValuesFrom("parameter", newBenchQuery())
+	if err != nil {
+		t.Fatalf("error getting values: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if values, err := getValuesFrom("parameter", newBenchQuery()); err != nil || !reflect.DeepEqual(values, expected) {
+				t.Errorf("invalid values: expected %v, got %v (error: %v)", expected, values, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPlanErrors(t *testing.T) {
+	type malformed struct {
+		ID    int    `parameter:"id,required"`
+		Owner string `parameter:"owner,bogus"`
+	}
+	// errors are reported in field order, even if the tag error is cached
+	var fieldErr *FieldError
+	if _, err := getValuesFrom("parameter", malformed{}); !errors.As(err, &fieldErr) {
+		t.Fatalf("expected *FieldError, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		var tagErr *TagError
+		_, err := getValuesFrom("parameter", malformed{ID: 1})
+		if !errors.As(err, &tagErr) || tagErr.Field != "Owner" {
+			t.Fatalf("expected *TagError on field Owner, got %v", err)
+		}
+		// callers get their own copy of the cached error
+		tagErr.Field = "modified"
+	}
+}
+
+// resetPlans empties the cache of compiled plans, so that benchmarks can
+// measure the cost of scanning without it.
+func resetPlans() {
+	plans.Range(func(key, _ interface{}) bool {
+		plans.Delete(key)
+		return true
+	})
+}
+
+func BenchmarkGetValuesFromStruct(b *testing.B) {
+	source := newBenchQuery()
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := getValuesFrom("parameter", source); err != nil {
+				b.Fatalf("error getting values: %v", err)
+			}
+		}
+	})
+	// the baseline compiles the plans on every call, as scanning did before
+	// they were cached
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			resetPlans()
+			if _, err := getValuesFrom("parameter", source); err != nil {
+				b.Fatalf("error getting values: %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkFrom(b *testing.B) {
+	source := newBenchQuery()
+	parent := New("https://www.example.com/{tenant}/items")
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := parent.New("GET", "").From(source).Err(); err != nil {
+				b.Fatalf("error applying source: %v", err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			resetPlans()
+			if err := parent.New("GET", "").From(source).Err(); err != nil {
+				b.Fatalf("error applying source: %v", err)
+			}
+		}
+	})
+}
@@ -6,18 +6,22 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/dihedron/go-log"
-	"github.com/fatih/structs"
 )
 
 type operation int8
@@ -53,16 +57,68 @@ type Builder struct {
 	// query is a set of values set in the URL as query parameters.
 	parameters url.Values
 
-	// variables is the set of values that will be used to replace placeholder in
-	// the resource path, e.g. variable "id" in the following URL will be replaced
+	// variables is the set of values that will be used to expand the URL as an
+	// RFC 6570 URI template, e.g. variable "id" in the following URL will be replaced
 	// using a value from the map: http://www.example.com/path/resource/{id};
+	// values are strings, lists ([]string) or associative arrays (map[string]string);
 	// variables are populated in a way similar to that of headers and parameters.
-	variables map[string]string
-
-	// entity is the entity provider; it will be used to generate the request
-	// entity as an io.Reader. Moreover, it will be queried to set the request
-	// content type.
-	body io.Reader
+	variables map[string]interface{}
+
+	// cookies are added to each request; they are inherited by sub-builders.
+	cookies []*http.Cookie
+
+	// jar stores the cookies set by the responses to the requests submitted via
+	// Do(), and replays them on later requests; it is shared by reference with
+	// all sub-builders and, if nil, the jar of the client (if any) is used.
+	jar http.CookieJar
+
+	// body is the entity provider; it will be used to generate a fresh request
+	// entity for each request, so it can be safely shared with sub-builders.
+	body *payload
+
+	// client is the HTTP client used to submit the requests generated by this
+	// builder; it is shared by pointer with all sub-builders and, if nil, the
+	// http.DefaultClient is used.
+	client *http.Client
+
+	// ctx is the context that will be attached to the requests generated by this
+	// builder; it is inherited by sub-builders and, if nil, context.Background()
+	// is used.
+	ctx context.Context
+
+	// retry is the retry policy applied by Do(); it is shared by pointer with
+	// all sub-builders and, if nil, requests are not retried.
+	retry *RetryPolicy
+
+	// maxResponseSize is the maximum size in bytes of the response bodies read
+	// by Do(); it is inherited by sub-builders and, if not positive, response
+	// bodies are read regardless of their size.
+	maxResponseSize int64
+
+	// tokens is the source of the tokens set in the Authorization header of each
+	// request; it is shared by pointer with all sub-builders.
+	tokens TokenSource
+
+	// signers are applied, in order, to each request right before it is returned
+	// by Make(); they are inherited by sub-builders.
+	signers []Signer
+
+	// hooks are applied, in order, to a copy of the builder each time a request
+	// is made; they are inherited by sub-builders.
+	hooks []Hook
+
+	// middlewares wrap, in order, the submission of each request via Do(); they
+	// are inherited by sub-builders.
+	middlewares []Middleware
+
+	// strict makes Make() fail if any placeholder in the URL has no matching
+	// variable, or any variable has no matching placeholder; it is inherited by
+	// sub-builders.
+	strict bool
+
+	// errs is the list of errors accumulated along the chain of calls; if any,
+	// it is returned by Make() instead of a request.
+	errs Errors
 }
 
 // New returns a new request builder; the URL can be omitted and specified
@@ -73,7 +129,7 @@ func New(url string) *Builder {
 		url:        url,
 		headers:    map[string][]string{},
 		parameters: map[string][]string{},
-		variables:  map[string]string{},
+		variables:  map[string]interface{}{},
 	}
 }
 
@@ -81,12 +137,24 @@ func New(url string) *Builder {
 // and/or the request URL.
 func (f *Builder) New(method, url string) *Builder {
 	clone := &Builder{
-		method:     f.method,
-		url:        f.url,
-		headers:    map[string][]string{},
-		parameters: map[string][]string{},
-		variables:  map[string]string{},
-		body:       f.body,
+		method:          f.method,
+		url:             f.url,
+		headers:         map[string][]string{},
+		parameters:      map[string][]string{},
+		variables:       map[string]interface{}{},
+		cookies:         append([]*http.Cookie(nil), f.cookies...),
+		jar:             f.jar,
+		body:            f.body,
+		client:          f.client,
+		ctx:             f.ctx,
+		retry:           f.retry,
+		maxResponseSize: f.maxResponseSize,
+		tokens:          f.tokens,
+		signers:         append([]Signer(nil), f.signers...),
+		hooks:           append([]Hook(nil), f.hooks...),
+		middlewares:     append([]Middleware(nil), f.middlewares...),
+		strict:          f.strict,
+		errs:            append(Errors(nil), f.errs...),
 	}
 	if method != "" {
 		clone.method = strings.ToUpper(method)
@@ -120,14 +188,23 @@ func (f *Builder) Base(url string) *Builder {
 	return f
 }
 
-// Path overrides the builder URL; absolute and relative URLs can be used.
+// Path overrides the builder URL; absolute and relative URLs can be used; if
+// either the base URL or the path cannot be parsed, a *URLError is recorded.
+// Escaped characters (e.g. encoded slashes) in both URLs are preserved.
 // TODO: improve documentation showing relative paths
 func (f *Builder) Path(path string) *Builder {
-	baseURL, baseErr := url.Parse(f.url)
-	pathURL, pathErr := url.Parse(path)
-	if baseErr == nil && pathErr == nil {
-		f.url = baseURL.ResolveReference(pathURL).String()
+	// braces are escaped so that URI template expressions do not prevent the
+	// escaped form of the path from being preserved, and so are percent signs,
+	// so that braces that were already escaped are not mistaken for them; the
+	// URLs are validated beforehand, since that hides invalid escapes
+	for _, u := range []string{f.url, path} {
+		if _, err := url.Parse(bracesEscaper.Replace(u)); err != nil {
+			return f.fail(&URLError{URL: u, Err: err})
+		}
 	}
+	baseURL, _ := url.Parse(templateEscaper.Replace(f.url))
+	pathURL, _ := url.Parse(templateEscaper.Replace(path))
+	f.url = templateUnescaper.Replace(baseURL.ResolveReference(pathURL).String())
 	return f
 }
 
@@ -208,7 +285,10 @@ func (f *Builder) QueryParameter(key string, values ...string) *Builder {
 	} else if f.op == del {
 		f.parameters.Del(key)
 	} else if f.op == rem {
-		re := regexp.MustCompile(key)
+		re, err := regexp.Compile(key)
+		if err != nil {
+			return f.fail(&PatternError{Pattern: key, Err: err})
+		}
 		for key := range f.parameters {
 			if re.MatchString(key) {
 				defer f.parameters.Del(key)
@@ -222,9 +302,14 @@ func (f *Builder) QueryParameter(key string, values ...string) *Builder {
 // tagged with "parameter") or from a map[string][]string to the URL's query
 // parameters; if the query parameters are being removed, there is no need to
 // specify any value in the input struct/map; if the query parameters are being
-// reset, the keys are regarded as regular expressions.
+// reset, the keys are regarded as regular expressions. Structs implementing
+// ParameterAppender provide their values without reflection.
 func (f *Builder) QueryParametersFrom(source interface{}) *Builder {
-	for key, values := range getValuesFrom("parameter", source) {
+	m, err := getValuesFrom("parameter", source)
+	if err != nil {
+		return f.fail(err)
+	}
+	for key, values := range m {
 		f.QueryParameter(key, values...)
 	}
 	return f
@@ -232,14 +317,30 @@ func (f *Builder) QueryParametersFrom(source interface{}) *Builder {
 
 // Variable adds, sets or removes the given value to the URL's variables; if the
 // variable is being removed, there is no need to specify the value; both setting
-// and adding a value for a given variable effectively replace its value.
+// and adding a value for a given variable effectively replace its value. The URL
+// is an RFC 6570 URI template, so values can be strings (or anything that can be
+// printed as one), slices (lists) and maps (associative arrays), which can be
+// expanded e.g. as path segments ("{/path*}") or query parameters ("{?map*}");
+// nil values are undefined, and expand to nothing. Values are escaped as path
+// segments, so that "/", "?" and "#" cannot alter the structure of the URL, and
+// values with dot segments (e.g. "../admin"), which servers resolve even if
+// escaped, make Make() fail with a *URLError wrapping ErrDotSegment in simple
+// and path segment expansions; reserved characters can be preserved only by
+// explicitly opting in to reserved expansion in the template (e.g. "{+path}").
 func (f *Builder) Variable(key string, value interface{}) *Builder {
 	if f.op == add || f.op == set {
-		f.variables[key] = fmt.Sprintf("%v", value)
+		if value := templateValue(value); value != nil {
+			f.variables[key] = value
+		} else {
+			delete(f.variables, key)
+		}
 	} else if f.op == del {
 		delete(f.variables, key)
 	} else if f.op == rem {
-		re := regexp.MustCompile(key)
+		re, err := regexp.Compile(key)
+		if err != nil {
+			return f.fail(&PatternError{Pattern: key, Err: err})
+		}
 		for key := range f.variables {
 			if re.MatchString(key) {
 				defer delete(f.variables, key)
@@ -250,16 +351,20 @@ func (f *Builder) Variable(key string, value interface{}) *Builder {
 }
 
 // VariablesFrom adds/sets or removes values extracted from a struct (and
-// tagged with "variable") or from a map[string]string to the URL's variables; if
-// the variables are being removed, there is no need to specify any value in the
-// input struct/map; if the variables are being reset, the keys are regarded as
-// regular expressions.
+// tagged with "variable") or from a map[string][]string to the URL's variables;
+// if the variables are being removed, there is no need to specify any value in
+// the input struct/map; if the variables are being reset, the keys are regarded
+// as regular expressions. Slices and maps in struct fields are set as lists and
+// associative arrays respectively, unless a delimiter or a style is selected in
+// the tag; if there are several values for a variable, the last one wins.
+// Structs implementing VariableAppender provide their values without reflection.
 func (f *Builder) VariablesFrom(source interface{}) *Builder {
-	for key, values := range getValuesFrom("variable", source) {
-		if len(values) > 0 {
-			// the last value wins
-			f.Variable(key, values[len(values)-1])
-		}
+	variables, err := getVariablesFrom(source)
+	if err != nil {
+		return f.fail(err)
+	}
+	for key, value := range variables {
+		f.Variable(key, value)
 	}
 	return f
 }
@@ -280,7 +385,10 @@ func (f *Builder) Header(key string, values ...string) *Builder {
 	} else if f.op == del {
 		f.headers.Del(key)
 	} else if f.op == rem {
-		re := regexp.MustCompile(key)
+		re, err := regexp.Compile(key)
+		if err != nil {
+			return f.fail(&PatternError{Pattern: key, Err: err})
+		}
 		for key := range f.headers {
 			if re.MatchString(key) {
 				defer f.headers.Del(key)
@@ -294,83 +402,433 @@ func (f *Builder) Header(key string, values ...string) *Builder {
 // with "header") or from a map[string][]string to the URL's headers; if the
 // headers are being removed, there is no need to  specify any value in the input
 // struct/map; if the headers are being reset, the keys are regarded as regular
-// expressions.
+// expressions. Structs implementing HeaderAppender provide their values without
+// reflection.
 func (f *Builder) HeadersFrom(source interface{}) *Builder {
-	for key, values := range getValuesFrom("header", source) {
+	m, err := getValuesFrom("header", source)
+	if err != nil {
+		return f.fail(err)
+	}
+	for key, values := range m {
+		f.Header(key, values...)
+	}
+	return f
+}
+
+// From populates the builder from a single struct describing the request, whose
+// fields declare where their values go via their tags, all extracted in a single
+// pass over the struct:
+//   - fields tagged with "variable", "parameter" and "header" are handled as by
+//     VariablesFrom(), QueryParametersFrom() and HeadersFrom(), according to the
+//     current operation (see Add(), Set(), Del() and Remove())
+//   - fields tagged with "cookie" are added as cookies, again according to the
+//     current operation
+//   - the field tagged with "body", if any, is the request entity, encoded in the
+//     format named in the tag: "json", "xml" or a content type with a registered
+//     Codec; io.Readers and []byte values are sent as they are
+//   - fields tagged with "form" make up a URL-encoded form entity.
+//
+// All tag options are supported (see Tag); a struct can have either a body field
+// or form fields, not both. Errors are recorded as by the individual methods.
+func (f *Builder) From(source interface{}) *Builder {
+	v := reflect.ValueOf(source)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return f.fail(&SourceError{Tag: "from", Source: source})
+	}
+	scanned, err := scanTags([]string{"variable", "parameter", "header", "cookie", "form", "body"}, v.Interface())
+	if err != nil {
+		return f.fail(err)
+	}
+
+	variables := map[string]interface{}{}
+	if err := templateValuesInto(scanned["variable"], variables); err != nil {
+		return f.fail(err)
+	}
+	values := map[string]map[string][]string{}
+	for _, tag := range []string{"parameter", "header", "cookie", "form"} {
+		if values[tag], err = marshalValues(tag, scanned[tag]); err != nil {
+			return f.fail(err)
+		}
+	}
+	for key, value := range variables {
+		f.Variable(key, value)
+	}
+	for key, values := range values["parameter"] {
+		f.QueryParameter(key, values...)
+	}
+	for key, values := range values["header"] {
 		f.Header(key, values...)
 	}
+	f.cookiesFrom(values["cookie"])
+
+	bodies := 0
+	for _, values := range scanned["body"] {
+		bodies += len(values)
+	}
+	if bodies > 1 {
+		return f.fail(&EntityError{Format: "body", Entity: source, Err: errors.New("more than one field tagged with \"body\"")})
+	}
+	if bodies > 0 && len(scanned["form"]) > 0 {
+		return f.fail(&EntityError{Format: "body", Entity: source, Err: errors.New("fields tagged with both \"body\" and \"form\"")})
+	}
+	if len(scanned["form"]) > 0 {
+		return f.withEntity("form", "application/x-www-form-urlencoded", values["form"])
+	}
+	for format, values := range scanned["body"] {
+		entity := values[0].value
+		switch {
+		case strings.Contains(format, "/") && isReader(entity):
+			f.ContentType(format)
+			fallthrough
+		case isReader(entity):
+			if b, ok := entity.([]byte); ok {
+				return f.WithEntity(bytes.NewReader(b))
+			}
+			return f.WithEntity(entity.(io.Reader))
+		case strings.EqualFold(format, "json"):
+			return f.WithJSONEntity(entity)
+		case strings.EqualFold(format, "xml"):
+			return f.WithXMLEntity(entity)
+		case strings.Contains(format, "/"):
+			return f.WithEntityAs(format, entity)
+		default:
+			return f.fail(&EntityError{Format: format, Entity: entity, Err: fmt.Errorf("unsupported body format %q: must be \"json\", \"xml\" or a content type", format)})
+		}
+	}
+	return f
+}
+
+// isReader returns whether the entity is an io.Reader or a []byte, which are
+// sent as they are.
+func isReader(entity interface{}) bool {
+	switch entity.(type) {
+	case io.Reader, []byte:
+		return true
+	}
+	return false
+}
+
+// Cookie adds, sets or removes the given cookie; if the cookie is being removed,
+// there is no need to specify its value; if the cookies are being reset, its name
+// is regarded as a regular expression. Setting a cookie replaces all cookies with
+// the same name. Cookies are added to each request and are inherited by
+// sub-builders; a copy of the given cookie is kept, so it can be reused.
+func (f *Builder) Cookie(cookie *http.Cookie) *Builder {
+	if cookie == nil {
+		return f
+	}
+	c := *cookie
+	return f.cookie(c.Name, &c)
+}
+
+// CookiesFrom adds, sets or removes cookies extracted from a struct (and tagged
+// with "cookie") or from a map[string][]string; if the cookies are being removed,
+// there is no need to specify any value in the input struct/map; if the cookies
+// are being reset, the names are regarded as regular expressions.
+func (f *Builder) CookiesFrom(source interface{}) *Builder {
+	m, err := getValuesFrom("cookie", source)
+	if err != nil {
+		return f.fail(err)
+	}
+	return f.cookiesFrom(m)
+}
+
+// cookiesFrom adds, sets or removes the cookies with the given names and values,
+// in order of name.
+func (f *Builder) cookiesFrom(values map[string][]string) *Builder {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cookies := make([]*http.Cookie, 0, len(values[name]))
+		for _, value := range values[name] {
+			cookies = append(cookies, &http.Cookie{Name: name, Value: value})
+		}
+		f.cookie(name, cookies...)
+	}
+	return f
+}
+
+// cookie adds, sets or removes the cookies with the given name.
+func (f *Builder) cookie(name string, cookies ...*http.Cookie) *Builder {
+	var matches func(string) bool
+	switch f.op {
+	case set, del:
+		matches = func(n string) bool { return n == name }
+	case rem:
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return f.fail(&PatternError{Pattern: name, Err: err})
+		}
+		matches = re.MatchString
+	}
+	if matches != nil {
+		retained := f.cookies[:0:0]
+		for _, cookie := range f.cookies {
+			if !matches(cookie.Name) {
+				retained = append(retained, cookie)
+			}
+		}
+		f.cookies = retained
+	}
+	if f.op == add || f.op == set {
+		f.cookies = append(f.cookies, cookies...)
+	}
 	return f
 }
 
 // WithEntity sets the io.Reader from which the request body (payload) will be
-// read; if nil is passed, the request will have no payload; the Content-Type
-// MUST be provoded separately.
+// read; if nil (or a nil pointer) is passed, the request will have no payload;
+// the Content-Type MUST be provoded separately. If the reader is a *bytes.Buffer, *bytes.Reader
+// or *strings.Reader, its contents are snapshotted and every request gets its
+// own fresh copy, otherwise the reader can only be consumed by one request
+// (use WithEntityFunc() for replayable streams).
 func (f *Builder) WithEntity(entity io.Reader) *Builder {
-	f.body = entity
+	f.body = newPayload(entity)
+	return f
+}
+
+// WithEntityFunc sets the function that will be called to open a fresh request
+// body (payload) for each generated request, e.g. by re-opening a file; this
+// makes the entity replayable on redirects and retries, even when it is not
+// held in memory. The length can be -1 if unknown. The Content-Type MUST be
+// provided separately. If the function is nil, an *EntityError is recorded.
+func (f *Builder) WithEntityFunc(open func() (io.ReadCloser, error), length int64) *Builder {
+	if open == nil {
+		return f.fail(&EntityError{Format: "stream", Entity: open, Err: errors.New("no function given to open the entity")})
+	}
+	f.body = newFuncPayload(open, length)
 	return f
 }
 
 // WithJSONEntity sets an io.Reader that returns a JSON fragment as per the
-// input struct; if no Content-Type has been set already, the method will
-// automatically set it to "application/json".
+// input value, which can be anything the JSON encoder can handle (structs,
+// maps, slices, primitives, json.RawMessage and json.Marshalers); if no
+// Content-Type has been set already, the method will automatically set it to
+// "application/json". The Codec registered for "application/json" is used, so
+// encoder options can be changed by registering a configured JSONCodec, or
+// per request via WithEntityCodec(). If the entity cannot be marshalled, an
+// *EntityError is recorded.
 func (f *Builder) WithJSONEntity(entity interface{}) *Builder {
+	return f.withEntity("JSON", "application/json", entity)
+}
 
-	switch reflect.ValueOf(entity).Kind() {
-	case reflect.Struct:
-		// do nothing, entity is already a struct, thus it's ok
-	case reflect.Ptr:
-		// override entity by the value it points to if it's a struct
-		if reflect.ValueOf(entity).Elem().Kind() == reflect.Struct {
-			entity = reflect.ValueOf(entity).Elem().Interface()
-		} else {
-			panic("only structs can be passed as source for JSON entities")
-		}
-	default:
-		panic("only structs can be passed as source for JSON entities")
+// WithXMLEntity sets an io.Reader that returns an XML fragment as per the
+// input value, which can be anything the XML encoder can handle (including
+// xml.Marshalers); if no Content-Type has been set already, the method will
+// automatically set it to "text/xml". The Codec registered for "text/xml" is
+// used, so encoder options can be changed by registering a configured XMLCodec,
+// or per request via WithEntityCodec(). If the entity cannot be marshalled, an
+// *EntityError is recorded.
+func (f *Builder) WithXMLEntity(entity interface{}) *Builder {
+	return f.withEntity("XML", "text/xml", entity)
+}
+
+// WithEntityAs sets an io.Reader that returns the entity as encoded by the
+// Codec registered for the given content type (see RegisterCodec()); if no
+// Content-Type has been set already, the method will automatically set it to
+// the given one. If there is no Codec for the content type or the entity
+// cannot be marshalled, an *EntityError is recorded.
+func (f *Builder) WithEntityAs(contentType string, entity interface{}) *Builder {
+	return f.withEntity(contentType, contentType, entity)
+}
+
+// WithEntityCodec sets an io.Reader that returns the entity as encoded by the
+// given Codec, regardless of the registered ones; this allows to use specific
+// encoder options (see JSONCodec and XMLCodec) for a single request. If no
+// Content-Type has been set already, the method will automatically set it to
+// the codec's one. If the codec is nil or the entity cannot be marshalled, an
+// *EntityError is recorded.
+func (f *Builder) WithEntityCodec(codec Codec, entity interface{}) *Builder {
+	if codec == nil || isNilReferenceType(codec) {
+		return f.fail(&EntityError{Format: "codec", Entity: entity, Err: errors.New("no codec given")})
 	}
+	return f.withEntityCodec(codec.ContentType(), codec.ContentType(), codec, entity)
+}
+
+// withEntity marshals the entity with the codec registered for the content type
+// and sets it as the request body.
+func (f *Builder) withEntity(format string, contentType string, entity interface{}) *Builder {
+	codec, ok := LookupCodec(contentType)
+	if !ok {
+		return f.fail(&EntityError{Format: format, Entity: entity, Err: fmt.Errorf("no codec registered for content type %q", contentType)})
+	}
+	return f.withEntityCodec(format, contentType, codec, entity)
+}
 
-	data, err := json.Marshal(entity)
+// withEntityCodec marshals the entity with the given codec and sets it as the
+// request body.
+func (f *Builder) withEntityCodec(format string, contentType string, codec Codec, entity interface{}) *Builder {
+	data, err := codec.Marshal(entity)
 	if err != nil {
-		return nil
+		return f.fail(&EntityError{Format: format, Entity: entity, Err: err})
 	}
 
 	if f.headers.Get("Content-Type") == "" {
-		f.ContentType("application/json")
+		f.ContentType(contentType)
 	}
 
-	f.body = bytes.NewReader(data)
+	f.body = newBytesPayload(data)
 	return f
 }
 
-// WithXMLEntity sets an io.Reader that returns an XML fragment as per the
-// input struct; if no Content-Type has been set already, the method will
-// automatically set it to "application/xml".
-func (f *Builder) WithXMLEntity(entity interface{}) *Builder {
+// Client sets the HTTP client that will be used to submit requests via Do(); if
+// nil is passed, the http.DefaultClient will be used.
+func (f *Builder) Client(client *http.Client) *Builder {
+	f.client = client
+	return f
+}
 
-	switch reflect.ValueOf(entity).Kind() {
-	case reflect.Struct:
-		// do nothing, entity is already a struct, thus it's ok
-	case reflect.Ptr:
-		// override entity by the value it points to if it's a struct
-		if reflect.ValueOf(entity).Elem().Kind() == reflect.Struct {
-			entity = reflect.ValueOf(entity).Elem().Interface()
-		} else {
-			panic("only structs can be passed as source for XML entities")
-		}
-	default:
-		panic("only structs can be passed as source for XML entities")
-	}
+// CookieJar sets the jar that stores the cookies set by the responses to the
+// requests submitted via Do(), and adds them to later requests, e.g. to keep a
+// session; it takes precedence over the jar of the client, if any. The jar is
+// shared with sub-builders, so that the whole family of builders shares the
+// same session (see net/http/cookiejar for an implementation).
+func (f *Builder) CookieJar(jar http.CookieJar) *Builder {
+	f.jar = jar
+	return f
+}
 
-	data, err := xml.Marshal(entity)
+// Context sets the context that will be attached to the requests generated by
+// this builder and by its sub-builders, so that deadlines and cancellation can
+// be part of the builder state.
+func (f *Builder) Context(ctx context.Context) *Builder {
+	f.ctx = ctx
+	return f
+}
+
+// WithFormEntity sets an io.Reader that returns a URL-encoded form as per the
+// input struct (whose fields are tagged with "form") or map[string][]string;
+// if no Content-Type has been set already, the method will automatically set
+// it to "application/x-www-form-urlencoded". If the source is neither a struct
+// nor a map, a *SourceError is recorded.
+func (f *Builder) WithFormEntity(source interface{}) *Builder {
+	m, err := getValuesFrom("form", source)
 	if err != nil {
-		return nil
+		return f.fail(err)
 	}
+	return f.withEntity("form", "application/x-www-form-urlencoded", m)
+}
 
-	if f.headers.Get("Content-Type") == "" {
-		f.ContentType("text/xml")
+// WithMultipartEntity sets an io.Reader that streams the given multipart form
+// as the request body, and sets the Content-Type to "multipart/form-data" with
+// the form boundary, replacing any previous value; parts are written to the
+// request as they are read, so files are never buffered in memory. If any
+// error was recorded while preparing the multipart form, it is recorded in
+// the builder too; if the multipart form is nil, an *EntityError is recorded.
+func (f *Builder) WithMultipartEntity(multipart *Multipart) *Builder {
+	if multipart == nil {
+		return f.fail(&EntityError{Format: "multipart", Entity: multipart, Err: errors.New("no multipart form given")})
+	}
+	if multipart.err != nil {
+		return f.fail(multipart.err)
 	}
 
-	f.body = bytes.NewReader(data)
+	f.ContentType(multipart.ContentType())
+
+	f.body = multipart.payload()
+	return f
+}
+
+// MaxResponseSize sets the maximum size in bytes of the response bodies read by
+// Do(), which fails with a *ResponseSizeError if a body is larger, instead of
+// reading it into memory in full; zero (the default) means no limit. Bodies too
+// large to be held in memory can be streamed by submitting the request returned
+// by Make() directly.
+func (f *Builder) MaxResponseSize(size int64) *Builder {
+	f.maxResponseSize = size
+	return f
+}
+
+// Retry sets the retry policy applied by Do() to the requests generated by this
+// builder and by its sub-builders; if nil is passed, requests are not retried.
+func (f *Builder) Retry(policy *RetryPolicy) *Builder {
+	f.retry = policy
+	return f
+}
+
+// BasicAuth sets the Authorization header for HTTP Basic authentication with
+// the given credentials, replacing any token source.
+func (f *Builder) BasicAuth(username, password string) *Builder {
+	f.tokens = nil
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return f.Set().Header("Authorization", "Basic "+credentials)
+}
+
+// DigestAuth enables HTTP Digest authentication (RFC 7616) with the given
+// credentials for requests submitted via Do(); the first request is sent
+// without credentials and, if challenged, replayed with the computed response,
+// while the following ones are authenticated preemptively. The challenge is
+// shared with sub-builders.
+func (f *Builder) DigestAuth(username, password string) *Builder {
+	f.tokens = nil
+	return f.Use(NewDigest(username, password).Middleware)
+}
+
+// BearerToken sets a static bearer token in the Authorization header, replacing
+// any token source.
+func (f *Builder) BearerToken(token string) *Builder {
+	return f.TokenSource(StaticToken(token))
+}
+
+// TokenSource sets the source of the tokens for the Authorization header; the
+// source is consulted each time a request is made, so long-lived builders (and
+// their sub-builders) pick up refreshed tokens. If the source fails, Make()
+// returns an *AuthError.
+func (f *Builder) TokenSource(source TokenSource) *Builder {
+	f.tokens = source
+	return f
+}
+
+// Sign appends the given signers to the builder; signers are applied, in order,
+// to each request as the very last step of Make(), so they can compute their
+// signatures over the final URL, headers and body. If a signer fails, Make()
+// returns a *SignatureError. Signers are inherited by sub-builders.
+func (f *Builder) Sign(signers ...Signer) *Builder {
+	f.signers = append(f.signers, signers...)
+	return f
+}
+
+// APIKeyHeader sets the API key in the given request header.
+func (f *Builder) APIKeyHeader(name, key string) *Builder {
+	return f.Set().Header(name, key)
+}
+
+// APIKeyParameter sets the API key in the given query parameter.
+func (f *Builder) APIKeyParameter(name, key string) *Builder {
+	return f.Set().QueryParameter(name, key)
+}
+
+// Strict enables or disables the strict binding of variables; in strict mode,
+// Make() fails with a *BindingError listing all the placeholders in the URL that
+// have no matching variable, and all the variables that are not used by any
+// placeholder, instead of silently expanding the former to nothing and ignoring
+// the latter. Strict mode is inherited by sub-builders.
+func (f *Builder) Strict(strict bool) *Builder {
+	f.strict = strict
+	return f
+}
+
+// Hook appends the given hooks to the builder; hooks are applied, in order, to
+// a copy of the builder right before each request is made, so they can add
+// headers, query parameters or variables (e.g. for tracing or quotas) without
+// affecting the builder itself. Hooks are inherited by sub-builders.
+func (f *Builder) Hook(hooks ...Hook) *Builder {
+	f.hooks = append(f.hooks, hooks...)
+	return f
+}
+
+// Use appends the given middlewares to the builder; middlewares wrap the
+// submission of each request via Do(), the first one being the outermost, so
+// they can inspect and modify both requests and responses (e.g. for logging or
+// signing). Middlewares are inherited by sub-builders.
+func (f *Builder) Use(middlewares ...Middleware) *Builder {
+	f.middlewares = append(f.middlewares, middlewares...)
 	return f
 }
 
@@ -419,32 +877,259 @@ func (f *Builder) Connect() *Builder {
 	return f.Method(http.MethodConnect)
 }
 
-// Make creates a new http.Request from the information available in the Builder.
+// Make creates a new http.Request from the information available in the Builder;
+// the request carries the builder's context, if any. If any error was recorded
+// along the chain of calls, it is returned instead (see Err()).
 func (f *Builder) Make() (*http.Request, error) {
+	return f.MakeWithContext(f.ctx)
+}
 
-	// parse URL to validate
-	url, err := url.Parse(f.url)
+// MakeWithContext creates a new http.Request from the information available in
+// the Builder, using the given context instead of the builder's one; if the
+// context is nil, context.Background() is used.
+func (f *Builder) MakeWithContext(ctx context.Context) (*http.Request, error) {
+
+	if err := f.Err(); err != nil {
+		return nil, err
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if len(f.hooks) == 0 {
+		return f.make(ctx)
+	}
+
+	// hooks work on a copy, so the builder is left untouched
+	clone := f.New("", "")
+	for _, hook := range f.hooks {
+		if err := hook(clone); err != nil {
+			return nil, err
+		}
+	}
+	if err := clone.Err(); err != nil {
+		return nil, err
+	}
+	return clone.make(ctx)
+}
+
+// make creates a new http.Request from the information available in the Builder,
+// without applying any hook.
+func (f *Builder) make(ctx context.Context) (*http.Request, error) {
+
+	// check that variables and placeholders match
+	if f.strict {
+		if err := checkVariables(f.url, f.variables); err != nil {
+			return nil, err
+		}
+	}
+
+	url, err := f.requestURL()
 	if err != nil {
 		return nil, err
 	}
 
-	// augment URL with additional query parameters
-	url, err = addQueryParameters(url, f.parameters)
+	request, err := http.NewRequestWithContext(ctx, f.method, url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
+	// each request gets its own fresh body
+	if f.body != nil {
+		if err := f.body.attach(request); err != nil {
+			return nil, &EntityError{Format: "raw", Err: err}
+		}
+	}
+
+	request.Header = f.headers.Clone()
+	for _, cookie := range f.cookies {
+		request.AddCookie(cookie)
+	}
+
+	if f.tokens != nil {
+		token, err := f.tokens.Token(ctx)
+		if err != nil {
+			return nil, &AuthError{Err: err}
+		}
+		if token == nil {
+			return nil, &AuthError{Err: ErrNoToken}
+		}
+		request.Header.Set("Authorization", token.String())
+	}
+
+	for _, signer := range f.signers {
+		if err := signer.Sign(request); err != nil {
+			return nil, &SignatureError{Err: err}
+		}
+	}
+
+	return request, nil
+}
+
+// requestURL binds the variables to the URL template and adds the query
+// parameters, returning the URL of the requests.
+func (f *Builder) requestURL() (*url.URL, error) {
 	// replace variables
-	u := bindVariables(url, f.variables)
+	u, err := bindVariables(f.url, f.variables)
+	if err != nil {
+		return nil, &URLError{URL: f.url, Err: err}
+	}
+
+	// parse URL to validate
+	url, err := url.Parse(u)
+	if err != nil {
+		return nil, &URLError{URL: f.url, Err: err}
+	}
+
+	// augment URL with additional query parameters
+	url, err = addQueryParameters(url, f.parameters)
+	if err != nil {
+		return nil, &URLError{URL: f.url, Err: err}
+	}
+	return url, nil
+}
+
+// Err returns the errors recorded along the chain of calls, if any: a single
+// error is returned as is, multiple errors are returned as Errors.
+func (f *Builder) Err() error {
+	switch len(f.errs) {
+	case 0:
+		return nil
+	case 1:
+		return f.errs[0]
+	}
+	return f.errs
+}
+
+// fail records an error in the builder and returns the builder itself, so that
+// the fluent API chain is never broken.
+func (f *Builder) fail(err error) *Builder {
+	f.errs = append(f.errs, err)
+	return f
+}
+
+// Do creates a new http.Request from the information available in the Builder,
+// submits it via the builder's http.Client and returns the response; the given
+// context is attached to the request, so it can be used to cancel it or to set
+// a deadline; if nil, the builder's context is used instead. The response body
+// is read in full (up to the limit set via MaxResponseSize(), if any) and closed
+// before returning, so there is no need for the caller to close it. If a retry policy is set (see Retry()), failed attempts
+// are retried as per the policy; moreover, if the server responds with 401 and
+// the builder's token source is an Invalidator, the token is invalidated and
+// the request is submitted once more with a fresh token.
+func (f *Builder) Do(ctx context.Context) (*Response, error) {
+
+	if ctx == nil {
+		ctx = f.ctx
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// work out whether the request can be retried
+	attempts, key := 1, ""
+	if f.retry != nil && f.retry.MaxAttempts > 1 {
+		if isIdempotent(f.method) || f.retry.RetryNonIdempotent {
+			attempts = f.retry.MaxAttempts
+		} else if f.retry.IdempotencyKey {
+			attempts = f.retry.MaxAttempts
+			if key = f.headers.Get("Idempotency-Key"); key == "" {
+				key = newIdempotencyKey()
+			}
+		}
+	}
+
+	// the key is set on a copy of the builder, so that it is part of the request
+	// seen by the signers
+	builder := f
+	if key != "" {
+		builder = f.New("", "").Set().Header("Idempotency-Key", key)
+	}
+
+	reauthenticated := false
+	for attempt := 1; ; attempt++ {
+		request, err := builder.MakeWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := f.send(request)
+		replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+		if err == nil && response.StatusCode == http.StatusUnauthorized && !reauthenticated && replayable {
+			// the token may have been revoked: drop it and try once more
+			if invalidator, ok := f.tokens.(Invalidator); ok {
+				log.Debugf("request unauthorized, invalidating token and retrying...")
+				invalidator.Invalidate()
+				reauthenticated = true
+				attempt--
+				continue
+			}
+		}
+		if attempt >= attempts || !replayable {
+			// no more attempts, or the body cannot be replayed
+			return response, err
+		}
+		delay, retry := f.retry.backoff(attempt, response, err)
+		if !retry {
+			return response, err
+		}
+		log.Debugf("attempt %d of %d failed, retrying in %v...", attempt, attempts, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
 
-	request, err := http.NewRequest(f.method, u, f.body)
+// send submits the request via the builder's http.Client, through the chain of
+// middlewares, and reads the whole response body, up to the maximum size if
+// any.
+func (f *Builder) send(request *http.Request) (*Response, error) {
+
+	client := f.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if f.jar != nil {
+		// a shallow copy, so that the shared client is not affected
+		c := *client
+		c.Jar = f.jar
+		client = &c
+	}
+
+	var transport http.RoundTripper = RoundTripperFunc(client.Do)
+	for i := len(f.middlewares) - 1; i >= 0; i-- {
+		transport = f.middlewares[i](transport)
+	}
+
+	response, err := transport.RoundTrip(request)
 	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
 
-	request.Header = f.headers
+	var body io.Reader = response.Body
+	if f.maxResponseSize > 0 {
+		if response.ContentLength > f.maxResponseSize {
+			return nil, &ResponseSizeError{Limit: f.maxResponseSize}
+		}
+		// one more byte is read, to tell whether the limit is exceeded
+		body = io.LimitReader(response.Body, f.maxResponseSize+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if f.maxResponseSize > 0 && int64(len(data)) > f.maxResponseSize {
+		return nil, &ResponseSizeError{Limit: f.maxResponseSize}
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(data))
 
-	return request, nil
+	return &Response{Response: response, data: data}, nil
 }
 
 // String prints the current request builder internal state as a string.
@@ -464,14 +1149,20 @@ func (f Builder) String() string {
 		Parameters: f.parameters,
 	}
 
-	if req, err := f.Make(); err == nil {
-		data.Request = req.URL.String()
+	// the URL is built without making a request, which would consult the token
+	// source and run the signers
+	if u, err := f.requestURL(); err == nil && len(f.errs) == 0 {
+		data.Request = u.String()
 	}
 
-	if f.body != nil {
-		data.Body = fmt.Sprintf("%q (%T)", f.body, f.body)
-	} else {
+	if f.body == nil {
 		data.Body = "nil"
+	} else if !f.body.replayable {
+		data.Body = "<stream>"
+	} else if b, err := f.body.bytes(); err == nil {
+		data.Body = fmt.Sprintf("%q", b)
+	} else {
+		data.Body = fmt.Sprintf("<error: %v>", err)
 	}
 
 	b, _ := json.MarshalIndent(data, "", "  ")
@@ -482,189 +1173,373 @@ func (f Builder) String() string {
 	return string(b)
 }
 
-func getValuesFrom(tag string, source interface{}) map[string][]string {
-	var m map[string][]string
+func getValuesFrom(tag string, source interface{}) (map[string][]string, error) {
+	if appender, ok := appenderOf(tag, source); ok {
+		values := map[string][]string{}
+		if err := appender(values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
 	switch reflect.ValueOf(source).Kind() {
 	case reflect.Struct:
-		m = getValuesFromStruct(tag, source)
+		return getValuesFromStruct(tag, source)
 	case reflect.Map:
-		var ok bool
-		if m, ok = source.(map[string][]string); !ok {
-			panic("only structs and maps can be passed as sources")
+		if m, ok := source.(map[string][]string); ok {
+			return m, nil
 		}
 	case reflect.Ptr:
 		if reflect.ValueOf(source).Elem().Kind() == reflect.Struct {
-			source = reflect.ValueOf(source).Elem().Interface()
-			m = getValuesFromStruct(tag, source)
-		} else if reflect.ValueOf(source).Elem().Kind() == reflect.Map {
-			source = reflect.ValueOf(source).Elem().Interface()
-			var ok bool
-			if m, ok = source.(map[string][]string); !ok {
-				panic("only structs and maps can be passed as sources")
-			}
-		} else {
-			panic("only structs and maps can be passed as sources")
+			return getValuesFromStruct(tag, reflect.ValueOf(source).Elem().Interface())
+		} else if m, ok := source.(*map[string][]string); ok {
+			return *m, nil
 		}
-	default:
-		panic("only structs and maps can be passed as sources")
 	}
-	return m
+	return nil, &SourceError{Tag: tag, Source: source}
 }
 
-func getValuesFromStruct(tag string, source interface{}) map[string][]string {
+// getValuesFromStruct extracts the values of the fields tagged with the given
+// tag and converts them into strings (see marshalValues()).
+func getValuesFromStruct(tag string, source interface{}) (map[string][]string, error) {
+	scanned, err := scan(tag, source)
+	if err != nil {
+		return nil, err
+	}
+	return marshalValues(tag, scanned)
+}
+
+// marshalValues converts the values extracted by scan() into strings (see
+// marshalValue()), flattening nested structs and maps if a style is selected
+// (see flatten()); if any value cannot be converted, a *FieldError is returned.
+func marshalValues(tag string, scanned map[string][]taggedValue) (map[string][]string, error) {
 	result := map[string][]string{}
-	for key, values := range scan(tag, source) {
-		// log.Debugf("tag is %q", key)
+	if err := marshalValuesInto(tag, scanned, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// marshalValuesInto is like marshalValues(), but adds the values to result.
+func marshalValuesInto(tag string, scanned map[string][]taggedValue, result map[string][]string) error {
+	for key, values := range scanned {
 		for _, value := range values {
-			s := ""
-			if reflect.ValueOf(value).Kind() == reflect.Ptr && !reflect.ValueOf(value).IsNil() {
-				s = fmt.Sprintf("%v", reflect.ValueOf(value).Elem().Interface())
-			} else {
-				s = fmt.Sprintf("%v", value)
+			if err := marshalField(tag, key, value.value, value.tag, "", result); err != nil {
+				return err
 			}
-			if _, ok := result[key]; !ok {
-				result[key] = []string{}
-			}
-			result[key] = append(result[key], s)
 		}
 	}
-	return result
+	return nil
 }
 
-func addQueryParameters(requestURL *url.URL, parameters url.Values) (*url.URL, error) {
-	qp, err := url.ParseQuery(requestURL.RawQuery)
+// getVariablesFrom extracts the values of the URL variables from a struct (see
+// templateValuesInto()) or from a map[string][]string, where the last value of
+// each key wins.
+func getVariablesFrom(source interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	if appender, ok := variableAppenderOf(source); ok {
+		if err := appender.AppendVariables(variables); err != nil {
+			return nil, err
+		}
+		return variables, nil
+	}
+	if s, ok := structValue(reflect.ValueOf(source)); ok {
+		return getVariablesFromStruct(s.Interface())
+	}
+	m, ok := source.(map[string][]string)
+	if p, isPtr := source.(*map[string][]string); isPtr && p != nil {
+		m, ok = *p, true
+	}
+	if !ok {
+		return nil, &SourceError{Tag: "variable", Source: source}
+	}
+	for key, values := range m {
+		if len(values) > 0 {
+			variables[key] = values[len(values)-1]
+		}
+	}
+	return variables, nil
+}
+
+// getVariablesFromStruct extracts the values of the fields tagged with
+// "variable" and converts them into URI template values (see
+// templateValuesInto()).
+func getVariablesFromStruct(source interface{}) (map[string]interface{}, error) {
+	scanned, err := scan("variable", source)
 	if err != nil {
 		return nil, err
 	}
-	// encodes query structs into a url.Values map and merges maps
-	for key, values := range parameters {
+	variables := map[string]interface{}{}
+	if err := templateValuesInto(scanned, variables); err != nil {
+		return nil, err
+	}
+	return variables, nil
+}
+
+// templateValuesInto converts the values extracted by scan() for the "variable"
+// tag into URI template values and sets them in variables (see
+// templateValueInto()); if there are several values for a key, the last one
+// wins.
+func templateValuesInto(scanned map[string][]taggedValue, variables map[string]interface{}) error {
+	for key, values := range scanned {
 		for _, value := range values {
-			qp.Add(key, value)
+			if err := templateValueInto(key, value, variables); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// templateValueInto converts a value extracted by scan() for the "variable" tag
+// into a URI template value and sets it in variables under the given name,
+// replacing any previous value: slices and maps are kept as lists of strings
+// and associative arrays respectively (see Variable()), unless a delimiter or a
+// style is selected in the tag, in which case they are converted as parameters
+// would be (see marshalField()) and, for each resulting key, the last string
+// wins; the elements of lists and the entries of maps are converted into
+// strings as single values are (see marshalValue()). Empty lists, which are
+// undefined in URI templates, leave any previous value in place.
+func templateValueInto(name string, value taggedValue, variables map[string]interface{}) error {
+	_, styled := value.tag.Option("style")
+	if _, ok := value.tag.Option("explode"); ok {
+		styled = true
+	}
+	if !styled && value.tag.Delimiter() == "" {
+		if isList(value.value) {
+			values, err := marshalValue(value.value, value.tag)
+			if err != nil {
+				return &FieldError{Tag: "variable", Name: name, Err: err}
+			}
+			if len(values) > 0 {
+				variables[name] = values
+			}
+			return nil
+		}
+		if v := reflect.Indirect(reflect.ValueOf(value.value)); v.Kind() == reflect.Map && !implementsMarshaler(v) {
+			entries := make(map[string]string, v.Len())
+			for _, key := range v.MapKeys() {
+				values, err := marshalValue(v.MapIndex(key).Interface(), value.tag)
+				if err != nil {
+					return &FieldError{Tag: "variable", Name: name, Err: err}
+				}
+				entries[fmt.Sprintf("%v", key.Interface())] = strings.Join(values, ",")
+			}
+			variables[name] = entries
+			return nil
+		}
+	}
+	result := map[string][]string{}
+	if err := marshalField("variable", name, value.value, value.tag, "", result); err != nil {
+		return err
+	}
+	for key, values := range result {
+		if len(values) > 0 {
+			variables[key] = values[len(values)-1]
 		}
 	}
-	// url.Values formats to a sorted "url encoded" string, e.g. "key=val&foo=bar"
-	requestURL.RawQuery = qp.Encode()
+	return nil
+}
+
+func addQueryParameters(requestURL *url.URL, parameters url.Values) (*url.URL, error) {
+	if _, err := url.ParseQuery(requestURL.RawQuery); err != nil {
+		return nil, err
+	}
+	if len(parameters) == 0 {
+		return requestURL, nil
+	}
+	// url.Values formats to a sorted "url encoded" string, e.g. "key=val&foo=bar";
+	// the query in the URL is kept as is, since it may come from the expansion of
+	// a URI template
+	if requestURL.RawQuery == "" {
+		requestURL.RawQuery = parameters.Encode()
+	} else {
+		requestURL.RawQuery += "&" + parameters.Encode()
+	}
 	return requestURL, nil
 }
 
-func bindVariables(u *url.URL, variables map[string]string) string {
-	re := regexp.MustCompile("\\{([_a-zA-Z]\\w*)\\}")
-	s, err := url.PathUnescape(u.String())
+// bindVariables expands the URL as an RFC 6570 URI template; escaped characters
+// (e.g. an encoded slash, or an encoded brace) are left untouched.
+func bindVariables(template string, variables map[string]interface{}) (string, error) {
+	log.Debugf("URL to bind: %q", template)
+	s, err := expandTemplate(template, variables)
 	if err != nil {
-		log.Errorf("error parsing URL: %v", err)
-		return ""
-	}
-
-	log.Debugf("URL to bind: %q", s)
-	matches := re.FindAllStringIndex(s, -1)
-	if len(matches) == 0 {
-		log.Debugf("no variables to bind, returning %q", u.String())
-		return u.String()
-	}
-	var buffer bytes.Buffer
-	pivot := 0
-	for no, match := range matches {
-		log.Debugf("match %d: %v (pivot: %d)", no, match, pivot)
-		log.Debugf("... grabbing substring: %q", s[pivot:match[0]])
-		buffer.WriteString(s[pivot:match[0]])
-		key := s[match[0]+1 : match[1]-1]
-		log.Debugf("... binding %q", key)
-		if value, ok := variables[key]; ok {
-			log.Debugf("... with value %q", value)
-			buffer.WriteString(value)
-		} else {
-			buffer.WriteString(s[match[0]:match[1]])
+		log.Errorf("error expanding URI template: %v", err)
+		return "", err
+	}
+	log.Debugf("URL bound to variables, returning %q", s)
+	return s, nil
+}
+
+// checkVariables returns a *BindingError if any placeholder in the URL template
+// has no matching variable, or any variable has no matching placeholder.
+func checkVariables(template string, variables map[string]interface{}) error {
+	names, err := templateNames(template)
+	if err != nil {
+		return &URLError{URL: template, Err: err}
+	}
+	bindingErr := &BindingError{URL: template}
+	used := map[string]bool{}
+	for _, name := range names {
+		used[name] = true
+		if _, ok := variables[name]; !ok {
+			bindingErr.Unbound = append(bindingErr.Unbound, name)
 		}
-		log.Debugf("... buffer now: %q", buffer.String())
-		pivot = match[1]
 	}
-	if pivot < len(s) {
-		log.Debugf("... appending final token: %q", s[pivot+1:])
+	for name := range variables {
+		if !used[name] {
+			bindingErr.Unused = append(bindingErr.Unused, name)
+		}
 	}
-	buffer.WriteString(s[pivot:])
-	s = buffer.String()
-	log.Debugf("URL bound to variables, returning %q", s)
-	return s
+	if len(bindingErr.Unbound) == 0 && len(bindingErr.Unused) == 0 {
+		return nil
+	}
+	sort.Strings(bindingErr.Unused)
+	return bindingErr
+}
+
+// bracesEscaper escapes the braces delimiting URI template expressions.
+var bracesEscaper = strings.NewReplacer("{", "%7B", "}", "%7D")
+
+// templateEscaper escapes the braces delimiting URI template expressions, and
+// percent signs, so that the URL can be parsed and the escaping reverted by
+// templateUnescaper without affecting characters that were already escaped.
+var templateEscaper = strings.NewReplacer("%", "%25", "{", "%7B", "}", "%7D")
+
+// templateUnescaper reverts the escaping of templateEscaper.
+var templateUnescaper = strings.NewReplacer("%25", "%", "%7B", "{", "%7D", "}")
+
+// taggedValue is a value extracted by scan(), along with the tag of its field.
+type taggedValue struct {
+	tag   Tag
+	value interface{}
 }
 
 // scan is the actual workhorse method: it scans the source struct for tagged
 // fields and extracts their values; its behaviour is the following:
-// - untagged embedded structs, child structs and pointers to structs are scanned
-//   recursively
-// - tagged embedded structs, child structs and pointers to structs are converted
-//   to string, provided they implement the Stringer interface, otherwise they
-//   are ignored.
-// - all other tagged values are extracted.
-func scan(key string, source interface{}) map[string][]interface{} {
-	result := map[string][]interface{}{}
-	for _, field := range structs.Fields(source) {
-		log.Debugf("analysing field %q for tag `%s`...", field.Name(), key)
-		tag := NewTag(field.Tag(key))
-		if tag.IsMissing() {
-			// untagged field
-			log.Debugf("... tag is missing")
-			if field.Kind() == reflect.Struct {
-				// recurse
-				log.Debugf("... field is a struct, recursing...")
-				for k, v := range scan(key, field.Value()) {
-					if values, ok := result[k]; ok {
-						result[k] = append(values, v...)
-					} else {
-						result[k] = v
-					}
-				}
-			} else if field.Kind() == reflect.Ptr && reflect.ValueOf(field.Value()).Elem().Kind() == reflect.Struct {
-				log.Debugf("... field is a struct pointer, recursing...")
-				for k, v := range scan(key, reflect.ValueOf(field.Value()).Elem().Interface()) {
-					if values, ok := result[k]; ok {
-						result[k] = append(values, v...)
-					} else {
-						result[k] = v
-					}
+//   - untagged embedded structs, child structs and pointers to structs are scanned
+//     recursively, and so are those tagged as "inline"; the entries of maps tagged
+//     as "inline" are extracted as if they were fields
+//   - tagged embedded structs, child structs and pointers to structs are extracted
+//     as values, to be converted to string by marshalValue() (e.g. time.Time, or
+//     types implementing encoding.TextMarshaler or fmt.Stringer).
+//   - zero values and nil references are replaced by the "default" value, if any,
+//     and cause a *FieldError if the field is "required"
+//   - all other tagged values are extracted, along with the tag of their field,
+//     under the name in the tag or, if it is empty, the name of the field.
+//
+// Malformed tags cause a *TagError.
+func scan(key string, source interface{}) (map[string][]taggedValue, error) {
+	result, err := scanTags([]string{key}, source)
+	if err != nil {
+		return nil, err
+	}
+	return result[key], nil
+}
+
+// scanTags scans the source struct for fields tagged with any of the given
+// keys in a single pass, and returns their values by key; fields are scanned
+// recursively only if they have none of the tags (see scan() for details). The
+// fields and their tags are read from the compiled plan of the struct type (see
+// planFor()), so reflection on types and tag parsing happen only once per type.
+func scanTags(keys []string, source interface{}) (map[string]map[string][]taggedValue, error) {
+	result := make(map[string]map[string][]taggedValue, len(keys))
+	for _, key := range keys {
+		result[key] = map[string][]taggedValue{}
+	}
+	v := reflect.ValueOf(source)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if err := scanStruct(keys, v, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scanStruct scans the given struct value according to its plan, adding the
+// extracted values to result.
+func scanStruct(keys []string, v reflect.Value, result map[string]map[string][]taggedValue) error {
+	plan := planFor(v.Type(), keys)
+	for _, field := range plan.fields {
+		fv := v.Field(field.index)
+		if len(field.tags) == 0 {
+			// untagged field, recurse into structs and pointers to structs
+			if s, ok := structValue(fv); ok {
+				if err := scanStruct(keys, s, result); err != nil {
+					return err
 				}
-			} else {
-				// ignore
-				log.Debugf("... untagged field or type %T, skipping...", field.Value())
-				continue
 			}
-		} else if tag.IsIgnore() {
-			// ignore
-			log.Debugf("... field is tagged with \"-\" (type: %T), skipping...", field.Value())
 			continue
-		} else {
-			// tagged field
-			k := tag.Name()
-			log.Debugf("... field is tagged with %q (type: %T)", k, field.Value())
-			var value interface{}
-			if field.Kind() == reflect.Struct {
-				log.Debugf("... field is a struct, adding as is under %q...", k)
-				value = field.Value()
-			} else if field.Kind() == reflect.Ptr && reflect.ValueOf(field.Value()).Elem().Kind() == reflect.Struct {
-				log.Debugf("... field is a struct pointer, adding after dereferencing under %q...", k)
-				value = reflect.ValueOf(field.Value()).Elem().Interface()
-			} else if isNilReferenceType(field.Value()) && tag.IsOmitEmpty() {
-				// ignore nil omitempty fields
-				log.Debugf("... field is nil reference and has \"omitempty\" (type: %T), skipping...", field.Value())
-				continue
-			} else if field.IsZero() && tag.IsOmitEmpty() {
-				// ignore zero values for omitempty fields
-				log.Debugf("... field is zero value and has \"omitempty\" (type: %T), skipping...", field.Value())
-				continue
-			} else if isZeroReferenceType(field.Value()) {
-				log.Debugf("... field is pointer to zero value and has \"omitempty\" (type: %T), skipping...", field.Value())
-				continue
-			} else {
-				log.Debugf("... field is a final value, adding as is under %q...", k)
-				value = field.Value()
+		}
+		for _, ft := range field.tags {
+			if err := scanField(ft, fv, result); err != nil {
+				return err
 			}
-			if values, ok := result[k]; ok {
-				result[k] = append(values, value)
-			} else {
-				result[k] = []interface{}{value}
+		}
+	}
+	if plan.err != nil {
+		err := *plan.err
+		return &err
+	}
+	return nil
+}
+
+// scanField extracts the value of a field tagged with the given tag and adds it
+// to result, unless it is skipped (see scan() for details).
+func scanField(ft fieldTag, fv reflect.Value, result map[string]map[string][]taggedValue) error {
+	key, k, tag := ft.key, ft.name, ft.tag
+	if tag.IsInline() && !tag.IsIgnore() {
+		// inline field, recurse into structs and add the entries of maps
+		if s, ok := structValue(fv); ok {
+			return scanStruct([]string{key}, s, result)
+		} else if fv.Kind() == reflect.Map {
+			for _, mk := range fv.MapKeys() {
+				name := fmt.Sprintf("%v", mk.Interface())
+				result[key][name] = append(result[key][name], taggedValue{tag: tag, value: fv.MapIndex(mk).Interface()})
 			}
 		}
+		return nil
+	}
+	if tag.IsIgnore() {
+		return nil
+	}
+	value := fv.Interface()
+	zero := isNilReferenceType(value) || fv.IsZero()
+	if zero {
+		if d, ok := tag.Default(); ok {
+			// the default is sent as it is, without applying the format
+			result[key][k] = append(result[key][k], taggedValue{tag: tag, value: literal(d)})
+			return nil
+		} else if tag.IsRequired() {
+			return &FieldError{Tag: key, Name: k, Err: ErrRequired}
+		}
+	}
+	if _, ok := structValue(fv); !ok {
+		if zero && tag.IsOmitEmpty() {
+			// ignore nil and zero values for omitempty fields
+			return nil
+		} else if isZeroReferenceType(value) && !tag.IsRequired() {
+			// ignore pointers to zero values
+			return nil
+		}
 	}
-	return result
+	// structs and pointers to structs are added as is, to be converted by
+	// marshalValue() or flattened by marshalField()
+	result[key][k] = append(result[key][k], taggedValue{tag: tag, value: value})
+	return nil
+}
+
+// structValue returns the struct held by the value, if it is a struct or a
+// non-nil pointer to a struct.
+func structValue(v reflect.Value) (reflect.Value, bool) {
+	switch {
+	case v.Kind() == reflect.Struct:
+		return v, true
+	case v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Struct:
+		return v.Elem(), true
+	}
+	return reflect.Value{}, false
 }
 
 func isNilReferenceType(value interface{}) bool {
@@ -680,6 +1555,9 @@ func isNilReferenceType(value interface{}) bool {
 
 func isZeroReferenceType(value interface{}) bool {
 	if reflect.ValueOf(value).Kind() == reflect.Ptr {
+		if reflect.ValueOf(value).IsNil() {
+			return true
+		}
 		current := reflect.ValueOf(value).Elem().Interface()
 		zero := reflect.Zero(reflect.ValueOf(current).Type()).Interface()
 		return reflect.DeepEqual(current, zero)
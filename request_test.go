@@ -5,11 +5,20 @@
 package request
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -402,8 +411,31 @@ func TestQueryParametersFrom(t *testing.T) {
 		}
 	}
 
-	defer handler("only structs and maps can be passed as sources", t)
-	New("").Add().QueryParametersFrom(&s)
+	var sourceErr *SourceError
+	if err := New("").Add().QueryParametersFrom(&s).Err(); !errors.As(err, &sourceErr) {
+		t.Fatalf("expected source error, got %v", err)
+	}
+
+	// slices and maps are set as lists and associative arrays, unless a
+	// delimiter is selected
+	type Composite struct {
+		P []string          `variable:"path"`
+		Q map[string]string `variable:"query"`
+		S []string          `variable:"scopes,space"`
+	}
+	composite := Composite{P: []string{"a", "b"}, Q: map[string]string{"q": "x y"}, S: []string{"read", "write"}}
+	for _, f := range []*Builder{
+		New("https://www.example.com/files{/path*}{?query*}{&scopes}").VariablesFrom(composite),
+		New("https://www.example.com/files{/path*}{?query*}{&scopes}").From(&composite),
+	} {
+		req, err := f.Make()
+		if err != nil {
+			t.Fatalf("error binding variables: %v", err)
+		}
+		if expected := "https://www.example.com/files/a/b?q=x%20y&scopes=read%20write"; req.URL.String() != expected {
+			t.Fatalf("invalid URL: expected %q, got %q", expected, req.URL.String())
+		}
+	}
 }
 
 func TestVariablesFrom(t *testing.T) {
@@ -469,8 +501,31 @@ func TestVariablesFrom(t *testing.T) {
 		}
 	}
 
-	defer handler("only structs and maps can be passed as sources", t)
-	New("").Add().QueryParametersFrom(&s)
+	var sourceErr *SourceError
+	if err := New("").Add().QueryParametersFrom(&s).Err(); !errors.As(err, &sourceErr) {
+		t.Fatalf("expected source error, got %v", err)
+	}
+
+	// slices and maps are set as lists and associative arrays, unless a
+	// delimiter is selected
+	type Composite struct {
+		P []string          `variable:"path"`
+		Q map[string]string `variable:"query"`
+		S []string          `variable:"scopes,space"`
+	}
+	composite := Composite{P: []string{"a", "b"}, Q: map[string]string{"q": "x y"}, S: []string{"read", "write"}}
+	for _, f := range []*Builder{
+		New("https://www.example.com/files{/path*}{?query*}{&scopes}").VariablesFrom(composite),
+		New("https://www.example.com/files{/path*}{?query*}{&scopes}").From(&composite),
+	} {
+		req, err := f.Make()
+		if err != nil {
+			t.Fatalf("error binding variables: %v", err)
+		}
+		if expected := "https://www.example.com/files/a/b?q=x%20y&scopes=read%20write"; req.URL.String() != expected {
+			t.Fatalf("invalid URL: expected %q, got %q", expected, req.URL.String())
+		}
+	}
 }
 
 func TestAddHeader(t *testing.T) {
@@ -618,7 +673,7 @@ func TestHeadersFrom(t *testing.T) {
 func TestWithEntity(t *testing.T) {
 	expected := "some text to send along"
 	f := New("").ContentType("text/plain").WithEntity(strings.NewReader(expected))
-	data, _ := ioutil.ReadAll(f.body)
+	data := readEntity(t, f)
 	actual := string(data)
 	if actual != expected {
 		t.Fatalf("error adding entity by reader: expected %s, got %s", expected, actual)
@@ -644,7 +699,7 @@ func TestWithJSONEntity(t *testing.T) {
 
 	// test with struct "by value"
 	f := New("").WithJSONEntity(a)
-	data, _ := ioutil.ReadAll(f.body)
+	data := readEntity(t, f)
 	actual := string(data)
 	if actual != expected {
 		t.Fatalf("error adding entity by reader: expected %s, got %s", expected, actual)
@@ -654,7 +709,7 @@ func TestWithJSONEntity(t *testing.T) {
 	}
 
 	f = New("").ContentType("application/my-type").WithJSONEntity(&a)
-	data, _ = ioutil.ReadAll(f.body)
+	data = readEntity(t, f)
 	actual = string(data)
 	if actual != expected {
 		t.Fatalf("error adding entity by reader: expected %s, got %s", expected, actual)
@@ -665,15 +720,60 @@ func TestWithJSONEntity(t *testing.T) {
 }
 
 func TestWithJSONEntityNoStruct(t *testing.T) {
-	defer handler("only structs can be passed as source for JSON entities", t)
 	s := "a string"
-	New("").WithJSONEntity(s)
+	tests := []struct {
+		entity   interface{}
+		expected string
+	}{
+		{s, `"a string"`},
+		{&s, `"a string"`},
+		{42, `42`},
+		{[]map[string]interface{}{{"a": 1}, {"b": true}}, `[{"a":1},{"b":true}]`},
+		{map[string]string{"html": "<b>&</b>"}, `{"html":"\u003cb\u003e\u0026\u003c/b\u003e"}`},
+		{json.RawMessage(`{"raw":true}`), `{"raw":true}`},
+	}
+	for _, test := range tests {
+		f := New("").WithJSONEntity(test.entity)
+		if err := f.Err(); err != nil {
+			t.Fatalf("error adding %T JSON entity: %v", test.entity, err)
+		}
+		data := readEntity(t, f)
+		if string(data) != test.expected {
+			t.Fatalf("error adding %T JSON entity: expected %s, got %s", test.entity, test.expected, string(data))
+		}
+	}
+
+	var entityErr *EntityError
+	if err := New("").WithJSONEntity(make(chan int)).Err(); !errors.As(err, &entityErr) {
+		t.Fatalf("expected entity error, got %v", err)
+	}
 }
 
-func TestWithJSONEntityNoStructPtr(t *testing.T) {
-	defer handler("only structs can be passed as source for JSON entities", t)
-	s := "a string"
-	New("").WithJSONEntity(&s)
+func TestWithEntityCodec(t *testing.T) {
+	entity := map[string]string{"html": "<b>&</b>"}
+
+	f := New("").WithEntityCodec(JSONCodec{Indent: "  ", DisableHTMLEscaping: true}, entity)
+	data := readEntity(t, f)
+	expected := "{\n  \"html\": \"<b>&</b>\"\n}"
+	if string(data) != expected {
+		t.Fatalf("error adding entity with codec options: expected %q, got %q", expected, string(data))
+	}
+	if f.headers.Get("Content-Type") != "application/json" {
+		t.Fatalf("error adding entity with codec options: content type is %q", f.headers.Get("Content-Type"))
+	}
+
+	type A struct {
+		Field1 string `xml:"field1"`
+	}
+	f = New("").WithEntityCodec(XMLCodec{Header: true, Indent: " "}, A{Field1: "value1"})
+	data = readEntity(t, f)
+	expected = xml.Header + "<A>\n <field1>value1</field1>\n</A>"
+	if string(data) != expected {
+		t.Fatalf("error adding entity with codec options: expected %q, got %q", expected, string(data))
+	}
+	if f.headers.Get("Content-Type") != "application/xml" {
+		t.Fatalf("error adding entity with codec options: content type is %q", f.headers.Get("Content-Type"))
+	}
 }
 
 func TestWithXMLEntity(t *testing.T) {
@@ -695,7 +795,7 @@ func TestWithXMLEntity(t *testing.T) {
 
 	// test with struct "by value"
 	f := New("").WithXMLEntity(a)
-	data, _ := ioutil.ReadAll(f.body)
+	data := readEntity(t, f)
 	actual := string(data)
 	if actual != expected {
 		t.Fatalf("error adding entity by reader: expected %s, got %s", expected, actual)
@@ -705,7 +805,7 @@ func TestWithXMLEntity(t *testing.T) {
 	}
 
 	f = New("").ContentType("application/my-type").WithXMLEntity(&a)
-	data, _ = ioutil.ReadAll(f.body)
+	data = readEntity(t, f)
 	actual = string(data)
 	if actual != expected {
 		t.Fatalf("error adding entity by reader: expected %s, got %s", expected, actual)
@@ -716,15 +816,22 @@ func TestWithXMLEntity(t *testing.T) {
 }
 
 func TestWithXMLEntityNoStruct(t *testing.T) {
-	defer handler("only structs can be passed as source for XML entities", t)
 	s := "a string"
-	New("").WithXMLEntity(s)
-}
+	for _, entity := range []interface{}{s, &s} {
+		f := New("").WithXMLEntity(entity)
+		if err := f.Err(); err != nil {
+			t.Fatalf("error adding %T XML entity: %v", entity, err)
+		}
+		data := readEntity(t, f)
+		if string(data) != "<string>a string</string>" {
+			t.Fatalf("error adding %T XML entity: expected <string>a string</string>, got %s", entity, string(data))
+		}
+	}
 
-func TestWithXMLEntityNoStructPtr(t *testing.T) {
-	defer handler("only structs can be passed as source for XML entities", t)
-	s := "a string"
-	New("").WithXMLEntity(&s)
+	var entityErr *EntityError
+	if err := New("").WithXMLEntity(map[string]string{"a": "b"}).Err(); !errors.As(err, &entityErr) {
+		t.Fatalf("expected entity error, got %v", err)
+	}
 }
 
 func TestMake(t *testing.T) {
@@ -845,7 +952,7 @@ func TestString(t *testing.T) {
 }
 
 func TestBindVariables(t *testing.T) {
-	variables := map[string]string{
+	variables := map[string]interface{}{
 		"var1": "value1",
 		"var2": "value2",
 		"var3": "value3",
@@ -857,20 +964,43 @@ func TestBindVariables(t *testing.T) {
 	}{
 		{
 			template: "https://example.com/foo/{var1}/{var2}/{var1}/{var3}/var1/var2/{var4}/{var1}-{var3}/bar?{var4}&foo=baz",
-			expected: "https://example.com/foo/value1/value2/value1/value3/var1/var2/{var4}/value1-value3/bar?{var4}&foo=baz",
+			expected: "https://example.com/foo/value1/value2/value1/value3/var1/var2//value1-value3/bar?&foo=baz",
 		},
 		{
 			template: "https://example.com/foo/{var1}/{var2}/{var1}/{var3}/var1/var2/{var4}/{var1}-{var3}/bar?{var4}",
-			expected: "https://example.com/foo/value1/value2/value1/value3/var1/var2/{var4}/value1-value3/bar?{var4}",
+			expected: "https://example.com/foo/value1/value2/value1/value3/var1/var2//value1-value3/bar?",
 		},
+		{
+			template: "https://example.com/foo{/var1,var4,var2}{?var3,var4}",
+			expected: "https://example.com/foo/value1/value2?var3=value3",
+		},
+	}
+	// braces escaped in the URL are data, while those escaped by Path() are
+	// template expressions
+	for _, test := range []struct {
+		builder  *Builder
+		expected string
+	}{
+		{New("http://example.com/search?filter=%7B%22a%22%3A1%7D"), "http://example.com/search?filter=%7B%22a%22%3A1%7D"},
+		{New("http://x/?q=%7Bid%7D").Variable("id", "v"), "http://x/?q=%7Bid%7D"},
+		{New("http://x/%7bid%7d/{id}").Variable("id", "v"), "http://x/%7bid%7d/v"},
+		{New("http://x/files/").Path("%7Bid%7D/{id}?q=%7B%7D&r={r}").Variable("id", "v").Variable("r", "w"), "http://x/files/%7Bid%7D/v?q=%7B%7D&r=w"},
+		{New("http://x/a%2Fb/{id}").Path("c%252F/{id}").Variable("id", "v"), "http://x/a%2Fb/c%252F/v"},
+	} {
+		req, err := test.builder.Make()
+		if err != nil {
+			t.Fatalf("error making request: %v", err)
+		}
+		if req.URL.String() != test.expected {
+			t.Fatalf("error, expected %q got %q", test.expected, req.URL.String())
+		}
 	}
+
 	for _, test := range tests {
-		u, err := url.Parse(test.template)
+		actual, err := bindVariables(test.template, variables)
 		if err != nil {
-			t.Fatalf("error parsing URL: %v", err)
+			t.Fatalf("error binding variables: %v", err)
 		}
-		s := bindVariables(u, variables)
-		actual, _ := url.PathUnescape(s)
 		if actual != test.expected {
 			t.Fatalf("error, expected %q got %q", test.expected, actual)
 		}
@@ -896,7 +1026,10 @@ func TestGetValuesFromStruct(t *testing.T) {
 		Dash: true,
 	}
 
-	results := getValuesFromStruct("parameter", testStruct)
+	results, err := getValuesFromStruct("parameter", testStruct)
+	if err != nil {
+		t.Fatalf("error getting values from struct: %v", err)
+	}
 
 	for key, values := range results {
 		t.Logf("%s => [", key)
@@ -927,12 +1060,443 @@ func TestGetValuesFromStruct(t *testing.T) {
 	}
 }
 
-func handler(message string, t *testing.T) {
-	if r := recover(); r != nil {
-		if r == message {
-			t.Logf("correctly recovered: %v", r)
-		} else {
-			t.Fatalf("unxpected panic: %v", r)
+type contextKey string
+
+func TestContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), contextKey("key"), "value")
+
+	f := New("https://www.example.com").Context(ctx)
+	req, err := f.Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Context().Value(contextKey("key")) != "value" {
+		t.Fatalf("invalid context: expected builder context to be attached to request")
+	}
+
+	// sub-builders inherit the context
+	req, err = f.New("", "/sub").Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Context().Value(contextKey("key")) != "value" {
+		t.Fatalf("invalid context: expected sub-builder to inherit parent context")
+	}
+
+	// explicit contexts override the builder's
+	other := context.WithValue(context.Background(), contextKey("key"), "other")
+	req, err = f.MakeWithContext(other)
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Context().Value(contextKey("key")) != "other" {
+		t.Fatalf("invalid context: expected explicit context to be attached to request")
+	}
+
+	// no context means background
+	req, err = New("https://www.example.com").Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Context() != context.Background() {
+		t.Fatalf("invalid context: expected background context")
+	}
+}
+
+func TestReplayableEntity(t *testing.T) {
+	tests := []struct {
+		builder  *Builder
+		expected string
+		length   int64
+	}{
+		{New("https://www.example.com").WithEntity(strings.NewReader("strings reader")), "strings reader", 14},
+		{New("https://www.example.com").WithEntity(bytes.NewReader([]byte("bytes reader"))), "bytes reader", 12},
+		{New("https://www.example.com").WithEntity(bytes.NewBufferString("bytes buffer")), "bytes buffer", 12},
+		{New("https://www.example.com").WithJSONEntity(map[string]int{"a": 1}), `{"a":1}`, 7},
+		{New("https://www.example.com").WithEntityFunc(func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("function")), nil
+		}, -1), "function", 0},
+	}
+
+	for _, test := range tests {
+		// the parent and a sub-builder can generate any number of requests
+		for _, f := range []*Builder{test.builder, test.builder, test.builder.New("", ""), test.builder.New("", "")} {
+			req, err := f.Make()
+			if err != nil {
+				t.Fatalf("error making request: %v", err)
+			}
+			if req.ContentLength != test.length {
+				t.Fatalf("invalid content length: expected %d, got %d", test.length, req.ContentLength)
+			}
+			data, _ := ioutil.ReadAll(req.Body)
+			if string(data) != test.expected {
+				t.Fatalf("invalid request body: expected %q, got %q", test.expected, string(data))
+			}
+			if req.GetBody == nil {
+				t.Fatalf("request body should be replayable")
+			}
+			body, _ := req.GetBody()
+			data, _ = ioutil.ReadAll(body)
+			if string(data) != test.expected {
+				t.Fatalf("invalid replayed request body: expected %q, got %q", test.expected, string(data))
+			}
 		}
 	}
+
+	// generic readers can only be consumed once
+	req, err := New("https://www.example.com").WithEntity(bufio.NewReader(strings.NewReader("stream"))).Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.GetBody != nil {
+		t.Fatalf("streamed request body should not be replayable")
+	}
+}
+
+func TestErr(t *testing.T) {
+	f := New("https://www.example.com").
+		Remove().
+		QueryParameter("[invalid").
+		Header("(invalid").
+		Variable("*invalid", nil).
+		Path("%zz")
+
+	err := f.Err()
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected multiple errors, got %v", err)
+	}
+	if len(errs) != 4 {
+		t.Fatalf("invalid number of errors: expected 4, got %d", len(errs))
+	}
+	var patternErr *PatternError
+	if !errors.As(err, &patternErr) || patternErr.Pattern != "[invalid" {
+		t.Fatalf("expected pattern error for \"[invalid\", got %v", patternErr)
+	}
+	var urlErr *URLError
+	if !errors.As(err, &urlErr) || urlErr.URL != "%zz" {
+		t.Fatalf("expected URL error for \"%%zz\", got %v", urlErr)
+	}
+	if _, err := f.Make(); err == nil {
+		t.Fatalf("expected error making request, got none")
+	}
+
+	// sub-builders inherit errors
+	if err := f.New("", "").Err(); err == nil {
+		t.Fatalf("expected sub-builder to inherit errors, got none")
+	}
+
+	if err := New("https://www.example.com").Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// nil entities are recorded as errors, or make no payload if readers
+	for _, f := range []*Builder{
+		New("https://www.example.com").WithMultipartEntity(nil),
+		New("https://www.example.com").WithEntityCodec(nil, "x"),
+		New("https://www.example.com").WithEntityCodec((*JSONCodec)(nil), "x"),
+		New("https://www.example.com").WithEntityFunc(nil, -1),
+	} {
+		var entityErr *EntityError
+		if err := f.Err(); !errors.As(err, &entityErr) {
+			t.Fatalf("expected *EntityError, got %v", err)
+		}
+	}
+	for _, entity := range []io.Reader{nil, (*bytes.Buffer)(nil), (*bytes.Reader)(nil), (*strings.Reader)(nil), (*os.File)(nil)} {
+		req, err := New("https://www.example.com").Post().WithEntity(entity).Make()
+		if err != nil || req.Body != nil || req.ContentLength != 0 {
+			t.Fatalf("expected no body for %T, got %v (error: %v)", entity, req, err)
+		}
+	}
+}
+
+// readEntity returns the entity of a request generated by the builder.
+func readEntity(t *testing.T, f *Builder) []byte {
+	req, err := f.Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("error reading request body: %v", err)
+	}
+	return data
+}
+
+func TestStrict(t *testing.T) {
+	parent := New("https://www.example.com/{tenant}/").Strict(true).Set().Variable("tenant", "acme")
+	if _, err := parent.Make(); err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+
+	// strict mode is inherited by sub-builders
+	child := parent.New("", "users/{id}{?fields}").Set().Variable("limit", 10).Variable("extra", "x")
+	_, err := child.Make()
+	var bindingErr *BindingError
+	if !errors.As(err, &bindingErr) {
+		t.Fatalf("expected binding error, got %v", err)
+	}
+	if strings.Join(bindingErr.Unbound, ",") != "id,fields" || strings.Join(bindingErr.Unused, ",") != "extra,limit" {
+		t.Fatalf("invalid binding error: got unbound %v, unused %v", bindingErr.Unbound, bindingErr.Unused)
+	}
+	expected := `invalid variables for URL "https://www.example.com/{tenant}/users/{id}{?fields}": unbound placeholders: id, fields; unused variables: extra, limit`
+	if err.Error() != expected {
+		t.Fatalf("invalid error message:\nexpected %q\ngot      %q", expected, err.Error())
+	}
+
+	// without strict mode, unbound placeholders expand to nothing
+	req, err := child.Strict(false).Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.URL.String() != "https://www.example.com/acme/users/" {
+		t.Fatalf("invalid URL: got %q", req.URL.String())
+	}
+}
+
+func TestFrom(t *testing.T) {
+	type Common struct {
+		Tenant string `variable:"tenant"`
+		Trace  string `header:"X-Trace-Id,omitempty"`
+	}
+	type Item struct {
+		Name  string `json:"name"`
+		Price int    `json:"price"`
+	}
+	type UpdateItem struct {
+		Common
+		ID      int      `variable:"id,required"`
+		Fields  []string `parameter:"fields,comma"`
+		Version int      `header:"If-Match" parameter:"version"`
+		Session string   `cookie:"session"`
+		Item    *Item    `body:"json"`
+	}
+
+	source := UpdateItem{
+		Common:  Common{Tenant: "acme", Trace: "abc"},
+		ID:      42,
+		Fields:  []string{"name", "price"},
+		Version: 3,
+		Session: "s3cr3t",
+		Item:    &Item{Name: "book", Price: 10},
+	}
+	f := New("https://www.example.com/{tenant}/items/{id}").Put().From(&source)
+	req, err := f.Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.URL.String() != "https://www.example.com/acme/items/42?fields=name%2Cprice&version=3" {
+		t.Fatalf("invalid URL: got %q", req.URL.String())
+	}
+	if req.Header.Get("X-Trace-Id") != "abc" || req.Header.Get("If-Match") != "3" || req.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("invalid headers: got %v", req.Header)
+	}
+	if cookies := req.Cookies(); len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value != "s3cr3t" {
+		t.Fatalf("invalid cookies: got %v", cookies)
+	}
+	if data := readEntity(t, f); string(data) != `{"name":"book","price":10}` {
+		t.Fatalf("invalid body: got %q", string(data))
+	}
+
+	// form fields and raw bodies
+	form := struct {
+		User     string `form:"user"`
+		Password string `form:"password"`
+	}{"me", "secret"}
+	if data := readEntity(t, New("https://www.example.com/login").Post().From(form)); string(data) != "password=secret&user=me" {
+		t.Fatalf("invalid form body: got %q", string(data))
+	}
+	raw := struct {
+		CSV *strings.Reader `body:"text/csv"`
+	}{strings.NewReader("a,b\n1,2\n")}
+	f = New("https://www.example.com/upload").Post().From(raw)
+	if data := readEntity(t, f); string(data) != "a,b\n1,2\n" {
+		t.Fatalf("invalid raw body: got %q", string(data))
+	}
+	if f.headers.Get("Content-Type") != "text/csv" {
+		t.Fatalf("invalid content type: got %q", f.headers.Get("Content-Type"))
+	}
+
+	// errors are recorded as by the individual methods
+	var entityErr *EntityError
+	var sourceErr *SourceError
+	var fieldErr *FieldError
+	tests := []struct {
+		source interface{}
+		target interface{}
+	}{
+		{struct {
+			A Item `body:"json"`
+			B Item `body:"xml"`
+		}{}, &entityErr},
+		{struct {
+			A Item   `body:"json"`
+			B string `form:"b"`
+		}{}, &entityErr},
+		{struct {
+			A Item `body:"yaml"`
+		}{}, &entityErr},
+		{struct {
+			ID int `variable:"id,required"`
+		}{}, &fieldErr},
+		{map[string][]string{}, &sourceErr},
+	}
+	for i, test := range tests {
+		if err := New("https://www.example.com/").From(test.source).Err(); !errors.As(err, test.target) {
+			t.Fatalf("test %d: expected %T, got %v", i, test.target, err)
+		}
+	}
+}
+
+func TestCookie(t *testing.T) {
+	session := &http.Cookie{Name: "session", Value: "old"}
+	parent := New("https://www.example.com/").
+		Add().Cookie(session).
+		Add().Cookie(&http.Cookie{Name: "theme", Value: "dark"}).
+		Add().Cookie(&http.Cookie{Name: "tracking_a", Value: "1"}).
+		Add().Cookie(&http.Cookie{Name: "tracking_b", Value: "2"}).
+		Add().Cookie(nil)
+	// the builder keeps a copy of the cookie
+	session.Value = "modified"
+
+	cookies := func(f *Builder) string {
+		req, err := f.Make()
+		if err != nil {
+			t.Fatalf("error making request: %v", err)
+		}
+		return req.Header.Get("Cookie")
+	}
+	if c := cookies(parent); c != "session=old; theme=dark; tracking_a=1; tracking_b=2" {
+		t.Fatalf("invalid cookies: got %q", c)
+	}
+	child := parent.New("", "").
+		Set().Cookie(&http.Cookie{Name: "session", Value: "new"}).
+		Del().Cookie(&http.Cookie{Name: "theme"}).
+		Remove().Cookie(&http.Cookie{Name: "^tracking_"})
+	if c := cookies(child); c != "session=new" {
+		t.Fatalf("invalid cookies in sub-builder: got %q", c)
+	}
+	if c := cookies(parent); c != "session=old; theme=dark; tracking_a=1; tracking_b=2" {
+		t.Fatalf("invalid cookies in parent after sub-builder changes: got %q", c)
+	}
+
+	var perr *PatternError
+	if err := New("").Remove().Cookie(&http.Cookie{Name: "[invalid"}).Err(); !errors.As(err, &perr) {
+		t.Fatalf("expected *PatternError, got %v", err)
+	}
+}
+
+func TestCookiesFrom(t *testing.T) {
+	type Preferences struct {
+		Theme    string `cookie:"theme,default=light"`
+		Language string `cookie:"lang,omitempty"`
+	}
+	source := struct {
+		Preferences
+		Session string   `cookie:"session,required"`
+		Flags   []string `cookie:"flag"`
+	}{Session: "s3cr3t", Flags: []string{"a", "b"}}
+
+	f := New("https://www.example.com/").Add().CookiesFrom(&source)
+	req, err := f.Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if c := req.Header.Get("Cookie"); c != "flag=a; flag=b; session=s3cr3t; theme=light" {
+		t.Fatalf("invalid cookies: got %q", c)
+	}
+	req, err = f.Set().CookiesFrom(map[string][]string{"flag": {"c"}}).Del().CookiesFrom(map[string][]string{"theme": nil}).Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if c := req.Header.Get("Cookie"); c != "session=s3cr3t; flag=c" {
+		t.Fatalf("invalid cookies: got %q", c)
+	}
+
+	var ferr *FieldError
+	if err := New("").CookiesFrom(struct {
+		Session string `cookie:"session,required"`
+	}{}).Err(); !errors.As(err, &ferr) {
+		t.Fatalf("expected *FieldError, got %v", err)
+	}
+	var serr *SourceError
+	if err := New("").CookiesFrom("session=s3cr3t").Err(); !errors.As(err, &serr) {
+		t.Fatalf("expected *SourceError, got %v", err)
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+		default:
+			cookie, err := r.Cookie("session")
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, "%s %s", cookie.Value, r.Header.Get("Cookie"))
+		}
+	}))
+	defer server.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("error creating cookie jar: %v", err)
+	}
+	client := server.Client()
+	parent := New(server.URL).Client(client).CookieJar(jar)
+	if res, err := parent.New(http.MethodPost, "/login").Do(context.Background()); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if client.Jar != nil {
+		t.Fatalf("expected the shared client not to be modified")
+	}
+
+	// the session is replayed by all the builders in the family, along with
+	// their own cookies
+	res, err := parent.New(http.MethodGet, "/profile").Add().Cookie(&http.Cookie{Name: "theme", Value: "dark"}).Do(context.Background())
+	if err != nil {
+		t.Fatalf("error getting profile: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || string(res.data) != "s3cr3t theme=dark; session=s3cr3t" {
+		t.Fatalf("invalid response: %d %q", res.StatusCode, string(res.data))
+	}
+
+	// builders without the jar have no session
+	if res, err := New(server.URL + "/profile").Client(client).Do(context.Background()); err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized response, got %v (error: %v)", res.StatusCode, err)
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	var count int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if r.URL.Path == "/chunked" {
+			// flushing before writing drops the Content-Length
+			w.(http.Flusher).Flush()
+		}
+		w.Write(bytes.Repeat([]byte("x"), 1024))
+	}))
+	defer server.Close()
+
+	parent := New(server.URL).Client(server.Client()).MaxResponseSize(1023)
+	for _, path := range []string{"/", "/chunked"} {
+		var serr *ResponseSizeError
+		if _, err := parent.New(http.MethodGet, path).Retry(DefaultRetryPolicy()).Do(context.Background()); !errors.As(err, &serr) || serr.Limit != 1023 {
+			t.Fatalf("expected *ResponseSizeError for %s, got %v", path, err)
+		}
+		res, err := parent.New(http.MethodGet, path).MaxResponseSize(1024).Do(context.Background())
+		if err != nil || len(res.Bytes()) != 1024 {
+			t.Fatalf("error reading response within the limit for %s: %v", path, err)
+		}
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 requests, got %d", count)
+	}
+	if res, err := New(server.URL).Client(server.Client()).Do(context.Background()); err != nil || len(res.Bytes()) != 1024 {
+		t.Fatalf("error reading response without limit: %v", err)
+	}
 }
@@ -0,0 +1,83 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Response wraps the http.Response returned by the server; by the time it is
+// handed over to the caller, the original body has already been read in full
+// and closed, and its contents are kept in memory so that they can be accessed
+// as many times as needed, either via Bytes() and String() or by reading the
+// (replaced) Body of the embedded http.Response.
+type Response struct {
+	*http.Response
+
+	// data is the payload of the response, as read from the original body.
+	data []byte
+}
+
+// Bytes returns the response payload as a byte slice.
+func (r *Response) Bytes() []byte {
+	return r.data
+}
+
+// String returns the response payload as a string.
+func (r *Response) String() string {
+	return string(r.data)
+}
+
+// IsSuccess returns whether the response has a 2xx status code.
+func (r *Response) IsSuccess() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Into decodes the response payload into the success target if the response
+// has a 2xx status code, or into the failure target otherwise; either target
+// can be nil, in which case the corresponding payload is not decoded. This is
+// the response-side counterpart of WithJSONEntity() and WithXMLEntity().
+func (r *Response) Into(success, failure interface{}) error {
+	if r.IsSuccess() {
+		if success != nil {
+			return r.Decode(success)
+		}
+	} else if failure != nil {
+		return r.Decode(failure)
+	}
+	return nil
+}
+
+// Decode decodes the response payload into the given target using the Codec
+// registered for the response Content-Type (see RegisterCodec()): out of the
+// box, JSON and XML payloads (including structured syntax suffixes such as
+// "application/problem+json") are unmarshalled into structs, form-encoded
+// payloads into a *url.Values or *map[string][]string, and plain text into a
+// *string or *[]byte; regardless of the content type, any payload can be
+// copied into a *string or a *[]byte. An empty payload leaves the target
+// untouched.
+func (r *Response) Decode(target interface{}) error {
+	if len(r.data) == 0 {
+		return nil
+	}
+
+	// raw targets accept any content type
+	switch t := target.(type) {
+	case *string:
+		*t = string(r.data)
+		return nil
+	case *[]byte:
+		*t = append((*t)[:0], r.data...)
+		return nil
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	codec, ok := LookupCodec(contentType)
+	if !ok {
+		return fmt.Errorf("unsupported content type %q for target of type %T", contentType, target)
+	}
+	return codec.Unmarshal(r.data, target)
+}
@@ -0,0 +1,159 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "hello %s", r.URL.Query().Get("name"))
+	}))
+	defer server.Close()
+
+	response, err := New(server.URL).
+		Client(server.Client()).
+		Post().
+		Add().
+		QueryParameter("name", "world").
+		Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if !response.IsSuccess() {
+		t.Fatalf("invalid status code: expected 200, got %d", response.StatusCode)
+	}
+	if response.String() != "hello world" {
+		t.Fatalf("invalid response payload: expected \"hello world\", got %q", response.String())
+	}
+	// the body can be read again after Do() has returned
+	data, _ := ioutil.ReadAll(response.Body)
+	if string(data) != "hello world" {
+		t.Fatalf("invalid response body: expected \"hello world\", got %q", string(data))
+	}
+
+	response, err = New(server.URL).Client(server.Client()).Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.IsSuccess() {
+		t.Fatalf("invalid status code: expected 405, got %d", response.StatusCode)
+	}
+}
+
+func TestDoWithCancelledContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := New(server.URL).Client(server.Client()).Do(ctx); err == nil {
+		t.Fatalf("expected error on context deadline, got none")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type Entity struct {
+		Name    string `json:"name" xml:"name"`
+		Surname string `json:"surname" xml:"surname"`
+	}
+
+	tests := []struct {
+		contentType string
+		payload     string
+	}{
+		{"application/json", `{"name":"John","surname":"Doe"}`},
+		{"application/json; charset=utf-8", `{"name":"John","surname":"Doe"}`},
+		{"application/vnd.example+json", `{"name":"John","surname":"Doe"}`},
+		{"application/xml", `<Entity><name>John</name><surname>Doe</surname></Entity>`},
+		{"text/xml", `<Entity><name>John</name><surname>Doe</surname></Entity>`},
+	}
+	for _, test := range tests {
+		response := newTestResponse(http.StatusOK, test.contentType, test.payload)
+		var entity Entity
+		if err := response.Decode(&entity); err != nil {
+			t.Fatalf("error decoding %q payload: %v", test.contentType, err)
+		}
+		if entity.Name != "John" || entity.Surname != "Doe" {
+			t.Fatalf("invalid %q decoded entity: got %+v", test.contentType, entity)
+		}
+	}
+
+	var values url.Values
+	if err := newTestResponse(http.StatusOK, "application/x-www-form-urlencoded", "a=1&a=2&b=3").Decode(&values); err != nil {
+		t.Fatalf("error decoding form payload: %v", err)
+	}
+	if len(values["a"]) != 2 || values.Get("b") != "3" {
+		t.Fatalf("invalid decoded form values: got %v", values)
+	}
+
+	var text string
+	if err := newTestResponse(http.StatusOK, "text/plain", "some text").Decode(&text); err != nil {
+		t.Fatalf("error decoding text payload: %v", err)
+	}
+	if text != "some text" {
+		t.Fatalf("invalid decoded text: expected \"some text\", got %q", text)
+	}
+
+	var entity Entity
+	if err := newTestResponse(http.StatusOK, "text/plain", "some text").Decode(&entity); err == nil {
+		t.Fatalf("expected error decoding text payload into struct, got none")
+	}
+}
+
+func TestInto(t *testing.T) {
+	type Success struct {
+		ID int `json:"id"`
+	}
+	type Failure struct {
+		Message string `json:"message"`
+	}
+
+	var success Success
+	var failure Failure
+	if err := newTestResponse(http.StatusCreated, "application/json", `{"id":42}`).Into(&success, &failure); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if success.ID != 42 || failure.Message != "" {
+		t.Fatalf("invalid decoded entities: got %+v and %+v", success, failure)
+	}
+
+	success, failure = Success{}, Failure{}
+	if err := newTestResponse(http.StatusNotFound, "application/json", `{"message":"not found"}`).Into(&success, &failure); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if success.ID != 0 || failure.Message != "not found" {
+		t.Fatalf("invalid decoded entities: got %+v and %+v", success, failure)
+	}
+
+	if err := newTestResponse(http.StatusNotFound, "application/json", `{"message":"not found"}`).Into(&success, nil); err != nil {
+		t.Fatalf("error decoding response without failure target: %v", err)
+	}
+}
+
+func newTestResponse(status int, contentType string, payload string) *Response {
+	return &Response{
+		Response: &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": []string{contentType}},
+		},
+		data: []byte(payload),
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy describes how failed requests are retried by Builder.Do(); only
+// idempotent methods (GET, HEAD, OPTIONS, TRACE, PUT and DELETE) are retried,
+// unless RetryNonIdempotent or IdempotencyKey are set. Requests whose body
+// cannot be replayed are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between attempts, if positive; it also
+	// caps the delay requested by the server via Retry-After.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the delay grows at each attempt; if not
+	// greater than 1, the delay is constant.
+	Multiplier float64
+	// Jitter is the fraction (between 0 and 1) of the delay that is randomised,
+	// so that clients do not retry all at the same time.
+	Jitter float64
+	// RetryableStatusCodes is the set of response status codes that trigger a
+	// retry; network errors always do.
+	RetryableStatusCodes []int
+	// RetryNonIdempotent allows retrying non-idempotent methods (e.g. POST).
+	RetryNonIdempotent bool
+	// IdempotencyKey allows retrying non-idempotent methods by sending the same
+	// Idempotency-Key header with every attempt; if the builder has no such
+	// header, a random key is generated.
+	IdempotencyKey bool
+}
+
+// DefaultRetryPolicy returns a policy with 3 attempts, exponential backoff from
+// 100ms up to 5s with 20% jitter, retrying on 408, 429, 500, 502, 503 and 504.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryableStatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// backoff returns whether the outcome of the given attempt should be retried
+// and, if so, how long to wait before retrying.
+func (p *RetryPolicy) backoff(attempt int, response *Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if !isRetryableError(err) {
+			return 0, false
+		}
+	} else if !p.isRetryableStatusCode(response.StatusCode) {
+		return 0, false
+	}
+
+	if response != nil {
+		if delay, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+			if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+				delay = p.MaxBackoff
+			}
+			return delay, true
+		}
+	}
+
+	delay := float64(p.InitialBackoff)
+	if p.Multiplier > 1 {
+		for i := 1; i < attempt; i++ {
+			delay *= p.Multiplier
+		}
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay -= delay * p.Jitter * mathrand.Float64()
+	}
+	return time.Duration(delay), true
+}
+
+// isRetryableStatusCode returns whether the status code triggers a retry.
+func (p *RetryPolicy) isRetryableStatusCode(code int) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryableError returns whether the error returned by the client is a
+// network error that is worth retrying; cancellations are never retried.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isIdempotent returns whether the HTTP method is idempotent as per RFC 7231.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which can be either
+// a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if delay := t.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// newIdempotencyKey returns a random key for the Idempotency-Key header.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// extremely unlikely, fall back to a time-based key
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
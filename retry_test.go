@@ -0,0 +1,264 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with the given status code,
+// then succeeds; it records the bodies and Idempotency-Key headers received.
+type flakyServer struct {
+	sync.Mutex
+	failures int
+	status   int
+	attempts int
+	bodies   []string
+	keys     []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.attempts++
+	data, _ := ioutil.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(data))
+	s.keys = append(s.keys, r.Header.Get("Idempotency-Key"))
+	if s.attempts <= s.failures {
+		w.WriteHeader(s.status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func testRetryPolicy() *RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 10 * time.Millisecond
+	return policy
+}
+
+func TestRetry(t *testing.T) {
+	handler := &flakyServer{failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	response, err := New(server.URL).
+		Client(server.Client()).
+		Retry(testRetryPolicy()).
+		Put().
+		WithEntity(strings.NewReader("payload")).
+		Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("invalid status code: expected 200, got %d", response.StatusCode)
+	}
+	if handler.attempts != 3 {
+		t.Fatalf("invalid number of attempts: expected 3, got %d", handler.attempts)
+	}
+	for _, body := range handler.bodies {
+		if body != "payload" {
+			t.Fatalf("invalid body in retried request: expected \"payload\", got %q", body)
+		}
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	handler := &flakyServer{failures: 5, status: http.StatusBadGateway}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	response, err := New(server.URL).Client(server.Client()).Retry(testRetryPolicy()).Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.StatusCode != http.StatusBadGateway {
+		t.Fatalf("invalid status code: expected 502, got %d", response.StatusCode)
+	}
+	if handler.attempts != 3 {
+		t.Fatalf("invalid number of attempts: expected 3, got %d", handler.attempts)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder func(url string) *Builder
+	}{
+		{"non-retryable status code", func(url string) *Builder {
+			return New(url).Get()
+		}},
+		{"non-idempotent method", func(url string) *Builder {
+			return New(url).Post()
+		}},
+		{"non-replayable body", func(url string) *Builder {
+			return New(url).Put().WithEntity(bufio.NewReader(strings.NewReader("payload")))
+		}},
+	}
+	for _, test := range tests {
+		status := http.StatusServiceUnavailable
+		if test.name == "non-retryable status code" {
+			status = http.StatusNotFound
+		}
+		handler := &flakyServer{failures: 1, status: status}
+		server := httptest.NewServer(handler)
+		response, err := test.builder(server.URL).Client(server.Client()).Retry(testRetryPolicy()).Do(context.Background())
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: error submitting request: %v", test.name, err)
+		}
+		if response.StatusCode != status {
+			t.Fatalf("%s: invalid status code: expected %d, got %d", test.name, status, response.StatusCode)
+		}
+		if handler.attempts != 1 {
+			t.Fatalf("%s: invalid number of attempts: expected 1, got %d", test.name, handler.attempts)
+		}
+	}
+}
+
+func TestRetryIdempotencyKey(t *testing.T) {
+	handler := &flakyServer{failures: 2, status: http.StatusTooManyRequests}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	policy := testRetryPolicy()
+	policy.IdempotencyKey = true
+	response, err := New(server.URL).
+		Client(server.Client()).
+		Retry(policy).
+		Post().
+		WithJSONEntity(map[string]string{"a": "b"}).
+		Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.StatusCode != http.StatusOK || handler.attempts != 3 {
+		t.Fatalf("invalid outcome: expected 200 after 3 attempts, got %d after %d", response.StatusCode, handler.attempts)
+	}
+	if handler.keys[0] == "" || handler.keys[0] != handler.keys[1] || handler.keys[1] != handler.keys[2] {
+		t.Fatalf("invalid idempotency keys: expected the same key for all attempts, got %q", handler.keys)
+	}
+}
+
+func TestRetryIdempotencyKeySigned(t *testing.T) {
+	handler := &flakyServer{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// the key must be in place when the signers run
+	policy := testRetryPolicy()
+	policy.IdempotencyKey = true
+	var signed []string
+	response, err := New(server.URL).
+		Client(server.Client()).
+		Retry(policy).
+		Sign(SignerFunc(func(req *http.Request) error {
+			signed = append(signed, req.Header.Get("Idempotency-Key"))
+			return nil
+		})).
+		Post().
+		Do(context.Background())
+	if err != nil {
+		t.Fatalf("error submitting request: %v", err)
+	}
+	if response.StatusCode != http.StatusOK || len(signed) != 2 || signed[0] == "" || signed[0] != signed[1] || signed[1] != handler.keys[1] {
+		t.Fatalf("invalid signed idempotency keys: got %q, sent %q", signed, handler.keys)
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	policy := testRetryPolicy()
+	start := time.Now()
+	if _, err := New(url).Retry(policy).Do(context.Background()); err == nil {
+		t.Fatalf("expected error submitting request to closed server, got none")
+	}
+	if time.Since(start) < policy.InitialBackoff {
+		t.Fatalf("expected connection errors to be retried")
+	}
+}
+
+func TestRetryContextCancelled(t *testing.T) {
+	handler := &flakyServer{failures: 5, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	policy := testRetryPolicy()
+	policy.InitialBackoff = time.Second
+	policy.MaxBackoff = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := New(server.URL).Client(server.Client()).Retry(policy).Do(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context deadline exceeded, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, time.March, 11, 22, 11, 16, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Sun, 11 Mar 2018 22:11:46 GMT", 30 * time.Second, true},
+		{"Sun, 11 Mar 2018 22:11:00 GMT", 0, true},
+		{"tomorrow", 0, false},
+	}
+	for _, test := range tests {
+		delay, ok := parseRetryAfter(test.value, now)
+		if delay != test.expected || ok != test.ok {
+			t.Fatalf("invalid Retry-After for %q: expected %v (%t), got %v (%t)", test.value, test.expected, test.ok, delay, ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := &RetryPolicy{
+		InitialBackoff:       100 * time.Millisecond,
+		MaxBackoff:           time.Second,
+		Multiplier:           2,
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+	}
+	response := newTestResponse(http.StatusServiceUnavailable, "text/plain", "")
+	for attempt, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second} {
+		delay, ok := policy.backoff(attempt+1, response, nil)
+		if !ok || delay != expected {
+			t.Fatalf("invalid backoff for attempt %d: expected %v, got %v", attempt+1, expected, delay)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay, _ := policy.backoff(1, response, nil)
+		if delay < 50*time.Millisecond || delay > 100*time.Millisecond {
+			t.Fatalf("invalid backoff with jitter: got %v", delay)
+		}
+	}
+
+	response.Header.Set("Retry-After", "3")
+	if delay, ok := policy.backoff(1, response, nil); !ok || delay != time.Second {
+		t.Fatalf("invalid backoff with Retry-After: expected 1s (capped), got %v", delay)
+	}
+
+	if _, ok := policy.backoff(1, newTestResponse(http.StatusBadRequest, "text/plain", ""), nil); ok {
+		t.Fatalf("non-retryable status code should not be retried")
+	}
+}
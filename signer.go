@@ -0,0 +1,52 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Signer signs requests, usually by adding headers; signers are applied by
+// Builder.Make() once the request is complete, so they see the final method,
+// URL and headers, and can read the body via http.Request.GetBody without
+// consuming it (see BodyDigest()).
+type Signer interface {
+	Sign(request *http.Request) error
+}
+
+// SignerFunc is an adapter to allow the use of ordinary functions as Signers.
+type SignerFunc func(*http.Request) error
+
+// Sign calls f(request).
+func (f SignerFunc) Sign(request *http.Request) error {
+	return f(request)
+}
+
+// ErrBodyNotReplayable is returned when a request body must be read in order to
+// sign the request, but it cannot be read without consuming it.
+var ErrBodyNotReplayable = errors.New("request body cannot be read without consuming it")
+
+// BodyDigest computes the digest of the request body with the given hash,
+// reading it via http.Request.GetBody so that the body itself is not consumed;
+// requests without a body have the digest of the empty string.
+func BodyDigest(request *http.Request, h hash.Hash) ([]byte, error) {
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody == nil {
+			return nil, ErrBodyNotReplayable
+		}
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
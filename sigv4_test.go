@@ -0,0 +1,119 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the following tests come from the AWS Signature Version 4 test suite.
+
+func testAWSSigner() *AWSSigner {
+	return &AWSSigner{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		now: func() time.Time {
+			return time.Date(2015, time.August, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+}
+
+func TestAWSSigner(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "get-vanilla",
+			path:     "/",
+			expected: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:     "get-vanilla-query-order-key-case",
+			path:     "/?Param2=value2&Param1=value1",
+			expected: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+	for _, test := range tests {
+		req, err := New("https://example.amazonaws.com").Path(test.path).Sign(testAWSSigner()).Make()
+		if err != nil {
+			t.Fatalf("%s: error making request: %v", test.name, err)
+		}
+		if actual := req.Header.Get("Authorization"); actual != test.expected {
+			t.Fatalf("%s: invalid signature:\nexpected %q\ngot      %q", test.name, test.expected, actual)
+		}
+		if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+			t.Fatalf("%s: invalid date: got %q", test.name, req.Header.Get("X-Amz-Date"))
+		}
+	}
+}
+
+func TestAWSSignerS3(t *testing.T) {
+	signer := testAWSSigner()
+	signer.Service = "s3"
+	signer.SessionToken = "session"
+
+	req, err := New("http://localhost:9000/bucket/{key}").
+		Put().
+		Set().
+		Variable("key", "object").
+		ContentType("text/plain").
+		WithEntity(strings.NewReader("Welcome to Amazon S3.")).
+		Sign(signer).
+		Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("X-Amz-Content-Sha256") != "44ce7dd67c959e0d3524ffac1771dfbba87d2b6b4b4e99e42034a8b803f8b072" {
+		t.Fatalf("invalid payload hash: got %q", req.Header.Get("X-Amz-Content-Sha256"))
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Fatalf("invalid session token: got %q", req.Header.Get("X-Amz-Security-Token"))
+	}
+	authorization := req.Header.Get("Authorization")
+	if !strings.Contains(authorization, "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") {
+		t.Fatalf("invalid signed headers: got %q", authorization)
+	}
+
+	// the body is still there to be sent
+	data := readEntity(t, New("http://localhost:9000/bucket").Put().WithEntity(strings.NewReader("payload")).Sign(signer))
+	if string(data) != "payload" {
+		t.Fatalf("signing should not consume the body: got %q", string(data))
+	}
+
+	signer.UnsignedPayload = true
+	req, err = New("http://localhost:9000/bucket/object").Put().Sign(signer).Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("X-Amz-Content-Sha256") != "UNSIGNED-PAYLOAD" {
+		t.Fatalf("invalid unsigned payload hash: got %q", req.Header.Get("X-Amz-Content-Sha256"))
+	}
+}
+
+func TestAWSEscape(t *testing.T) {
+	if actual := awsEscape("a b/c~d*e"); actual != "a%20b%2Fc~d%2Ae" {
+		t.Fatalf("invalid escaping: got %q", actual)
+	}
+}
+
+func TestAWSCanonicalization(t *testing.T) {
+	u, _ := url.Parse("https://s3.amazonaws.com/bucket/a%2Fb/c%20d?id=1&id2=2&a-b=3&a=4&a=0")
+	if actual := awsCanonicalQuery(u); actual != "a=0&a=4&a-b=3&id=1&id2=2" {
+		t.Fatalf("invalid canonical query: got %q", actual)
+	}
+	if actual := awsCanonicalPath(u, false); actual != "/bucket/a%2Fb/c%20d" {
+		t.Fatalf("invalid canonical path: got %q", actual)
+	}
+	if actual := awsCanonicalPath(u, true); actual != "/bucket/a%252Fb/c%2520d" {
+		t.Fatalf("invalid double-encoded canonical path: got %q", actual)
+	}
+}
@@ -5,27 +5,195 @@
 package request
 
 import (
+	"fmt"
 	"strings"
 )
 
-// Tag represents the value of a tag on a tagged struct field.
+// Tag represents the value of a tag on a tagged struct field; its grammar is
+// the following:
+//
+//	tag    = name *( "," option )
+//	name   = any sequence of characters but ","
+//	option = flag / key "=" value
+//	flag   = "omitempty" / "required" / "inline" / "explode"
+//	       / "comma" / "space" / "pipe" / "-"
+//	key    = "default" / "format" / "delimiter" / "style" / "explode"
+//	value  = any sequence of characters but "," / quoted
+//	quoted = "'" any sequence of characters, with "\'" and "\\" escapes, "'"
+//
+// The meaning of the options is the following:
+//   - omitempty skips zero values and nil references
+//   - required makes zero values and nil references an error
+//   - inline extracts the fields of a nested struct (or the entries of a map) as
+//     if they belonged to the enclosing struct
+//   - default gives the value used in place of zero values and nil references,
+//     as it is (the format is not applied to it)
+//   - format gives the time layout for time.Time values (see marshalValue()),
+//     the base for integers ("binary", "octal", "hex", or "base" followed by a
+//     number between 2 and 36) or a fmt verb (e.g. "%.2f") for any other value
+//   - explode (or "explode=true") expands slices into repeated keys, which is
+//     the default, while "explode=false" joins them with commas; on structs and
+//     maps, it selects the "form" style
+//   - comma, space and pipe join slices with the given delimiter, delimiter
+//     with any other (e.g. "delimiter=';'")
+//   - style selects how nested structs and maps are flattened: "bracket",
+//     "dot", "deepObject" or "form" (see flatten()).
+//
+// If the name is empty, the name of the field is used; if it is "-", the field
+// is ignored.
 type Tag struct {
-	tag string
+	tag     string
+	name    string
+	options map[string]string
 }
 
-// NewTag creates a new Tag struct from the given tag value.
+// tagOptions maps the supported tag options to whether they require a value
+// (true), do not accept one (false) or both (missing).
+var tagOptions = map[string]bool{
+	"-":         false,
+	"omitempty": false,
+	"required":  false,
+	"inline":    false,
+	"comma":     false,
+	"space":     false,
+	"pipe":      false,
+	"default":   true,
+	"format":    true,
+	"delimiter": true,
+	"style":     true,
+}
+
+// tagStyles lists the supported values of the "style" option, in lowercase.
+var tagStyles = map[string]bool{
+	"bracket":    true,
+	"dot":        true,
+	"deepobject": true,
+	"form":       true,
+}
+
+// NewTag creates a new Tag struct from the given tag value; malformed options
+// are ignored, use ParseTag() to detect them.
 func NewTag(tag string) Tag {
-	return Tag{tag: tag}
+	t, _ := ParseTag(tag)
+	return t
+}
+
+// ParseTag parses the given tag value according to the grammar described in
+// the Tag documentation; if the tag is malformed, a *TagError is returned
+// along with a Tag holding the options parsed so far.
+func ParseTag(tag string) (Tag, error) {
+	t := Tag{tag: tag, options: map[string]string{}}
+	i := strings.IndexByte(tag, ',')
+	if i < 0 {
+		t.name = strings.TrimSpace(tag)
+		return t, nil
+	}
+	t.name = strings.TrimSpace(tag[:i])
+
+	s, pos := tag[i+1:], 0
+	for pos <= len(s) {
+		start := pos
+		for pos < len(s) && s[pos] != ',' && s[pos] != '=' {
+			pos++
+		}
+		key := strings.TrimSpace(s[start:pos])
+		value, hasValue := "", false
+		if pos < len(s) && s[pos] == '=' {
+			hasValue = true
+			pos++
+			for pos < len(s) && s[pos] == ' ' {
+				pos++
+			}
+			if pos < len(s) && s[pos] == '\'' {
+				var buffer strings.Builder
+				closed := false
+				for pos++; pos < len(s); pos++ {
+					if s[pos] == '\\' && pos+1 < len(s) {
+						pos++
+					} else if s[pos] == '\'' {
+						closed = true
+						pos++
+						break
+					}
+					buffer.WriteByte(s[pos])
+				}
+				if !closed {
+					return t, &TagError{Tag: tag, Err: fmt.Errorf("unterminated quoted value for option %q", key)}
+				}
+				for pos < len(s) && s[pos] == ' ' {
+					pos++
+				}
+				if pos < len(s) && s[pos] != ',' {
+					return t, &TagError{Tag: tag, Err: fmt.Errorf("unexpected characters after quoted value for option %q", key)}
+				}
+				value = buffer.String()
+			} else {
+				start := pos
+				for pos < len(s) && s[pos] != ',' {
+					pos++
+				}
+				value = strings.TrimSpace(s[start:pos])
+			}
+		}
+		pos++
+
+		if key == "" {
+			if hasValue {
+				return t, &TagError{Tag: tag, Err: fmt.Errorf("missing option name before %q", "="+value)}
+			}
+			// empty options (e.g. a trailing comma) are tolerated
+			continue
+		}
+		if err := checkTagOption(key, value, hasValue); err != nil {
+			return t, &TagError{Tag: tag, Err: err}
+		}
+		if _, ok := t.options[key]; ok {
+			return t, &TagError{Tag: tag, Err: fmt.Errorf("duplicate option %q", key)}
+		}
+		t.options[key] = value
+	}
+
+	delimiters := 0
+	for _, option := range []string{"comma", "space", "pipe", "delimiter"} {
+		if _, ok := t.options[option]; ok {
+			delimiters++
+		}
+	}
+	if delimiters > 1 {
+		return t, &TagError{Tag: tag, Err: fmt.Errorf("only one of options \"comma\", \"space\", \"pipe\" and \"delimiter\" can be given")}
+	}
+	return t, nil
+}
+
+// checkTagOption checks that the option is supported and that its value, if
+// any, is valid.
+func checkTagOption(key, value string, hasValue bool) error {
+	if key == "explode" {
+		if hasValue && value != "true" && value != "false" {
+			return fmt.Errorf("invalid value %q for option %q: must be \"true\" or \"false\"", value, key)
+		}
+		return nil
+	}
+	requiresValue, ok := tagOptions[key]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown option %q", key)
+	case requiresValue && !hasValue:
+		return fmt.Errorf("option %q requires a value (e.g. %s=...)", key, key)
+	case !requiresValue && hasValue:
+		return fmt.Errorf("option %q does not take a value", key)
+	case (key == "format" || key == "delimiter") && value == "":
+		return fmt.Errorf("empty value for option %q", key)
+	case key == "style" && !tagStyles[strings.ToLower(value)]:
+		return fmt.Errorf("unsupported style %q: must be one of \"bracket\", \"dot\", \"deepObject\" and \"form\"", value)
+	}
+	return nil
 }
 
 // Name extracts the name of the tag, that is the first value in the list of
 // comma-separated values that can be given in a tagged struct field.
 func (t Tag) Name() string {
-	tokens := strings.Split(t.tag, ",")
-	if len(tokens) > 0 {
-		return strings.TrimSpace(tokens[0])
-	}
-	return ""
+	return t.name
 }
 
 // IsMissing returns whether the tag on the field is missing/invalid (i.e. empty).
@@ -38,21 +206,64 @@ func (t Tag) IsMissing() bool {
 // with respect to this specific tag handling (e.g. `json:"-"` means "do not
 // marshal when writing to JSON).
 func (t Tag) IsIgnore() bool {
-	for _, token := range strings.Split(t.tag, ",") {
-		if strings.TrimSpace(token) == "-" {
-			return true
-		}
-	}
-	return false
+	_, ok := t.options["-"]
+	return t.name == "-" || ok
 }
 
 // IsOmitEmpty returns whether the tag should be ignored when it contains an empty
 // value (e.g. a null pointer).
 func (t Tag) IsOmitEmpty() bool {
-	for _, token := range strings.Split(t.tag, ",") {
-		if strings.TrimSpace(token) == "omitempty" {
-			return true
-		}
+	_, ok := t.options["omitempty"]
+	return ok
+}
+
+// IsRequired returns whether the field must have a non-empty value.
+func (t Tag) IsRequired() bool {
+	_, ok := t.options["required"]
+	return ok
+}
+
+// IsInline returns whether the fields of a nested struct, or the entries of a
+// map, should be extracted as if they belonged to the enclosing struct.
+func (t Tag) IsInline() bool {
+	_, ok := t.options["inline"]
+	return ok
+}
+
+// Default returns the default value of the field, and whether one is given.
+func (t Tag) Default() (string, bool) {
+	value, ok := t.options["default"]
+	return value, ok
+}
+
+// Option returns the value of the given option, and whether the option is
+// present in the tag; options follow the name, and can be either flags (e.g.
+// "omitempty") or key/value pairs (e.g. "format=unix"), in which case the
+// value is returned.
+func (t Tag) Option(name string) (string, bool) {
+	value, ok := t.options[name]
+	return value, ok
+}
+
+// Delimiter returns the delimiter used to join the elements of slices into a
+// single value, as selected by the "delimiter", "comma", "space" or "pipe"
+// options, or by "explode=false"; if none is given, an empty string is
+// returned and slices are expanded into repeated keys.
+func (t Tag) Delimiter() string {
+	if delimiter, ok := t.options["delimiter"]; ok {
+		return delimiter
 	}
-	return false
+	if _, ok := t.options["comma"]; ok {
+		return ","
+	}
+	if _, ok := t.options["space"]; ok {
+		return " "
+	}
+	if _, ok := t.options["pipe"]; ok {
+		return "|"
+	}
+	if t.options["explode"] == "false" {
+		return ","
+	}
+	return ""
 }
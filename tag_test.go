@@ -5,6 +5,7 @@
 package request
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/fatih/structs"
@@ -77,3 +78,75 @@ func TestTag(t *testing.T) {
 		}
 	}
 }
+
+func TestTagOptions(t *testing.T) {
+	tag := NewTag("name,omitempty,format=unix,comma")
+	if value, ok := tag.Option("format"); !ok || value != "unix" {
+		t.Fatalf("invalid format option: got %q, %t", value, ok)
+	}
+	if _, ok := tag.Option("omitempty"); !ok {
+		t.Fatalf("omitempty option not found")
+	}
+	if _, ok := tag.Option("name"); ok {
+		t.Fatalf("name must not be regarded as an option")
+	}
+	if tag.Delimiter() != "," {
+		t.Fatalf("invalid delimiter: got %q", tag.Delimiter())
+	}
+	for value, expected := range map[string]string{"x,space": " ", "x,pipe": "|", "x": ""} {
+		if actual := NewTag(value).Delimiter(); actual != expected {
+			t.Fatalf("invalid delimiter for %q: expected %q, got %q", value, expected, actual)
+		}
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	tag, err := ParseTag(` id , required, default='a, b \'c\' \\d' ,format=%05d,explode=false,style=deepObject,`)
+	if err != nil {
+		t.Fatalf("error parsing tag: %v", err)
+	}
+	if tag.Name() != "id" || !tag.IsRequired() || tag.IsInline() || tag.IsOmitEmpty() {
+		t.Fatalf("invalid tag: got %+v", tag)
+	}
+	if value, _ := tag.Default(); value != `a, b 'c' \d` {
+		t.Fatalf("invalid default: got %q", value)
+	}
+	if value, _ := tag.Option("format"); value != "%05d" {
+		t.Fatalf("invalid format: got %q", value)
+	}
+	if value, _ := tag.Option("style"); value != "deepObject" {
+		t.Fatalf("invalid style: got %q", value)
+	}
+	if tag.Delimiter() != "," {
+		t.Fatalf("invalid delimiter for explode=false: got %q", tag.Delimiter())
+	}
+	if tag, _ := ParseTag(",inline,delimiter=';'"); tag.Name() != "" || !tag.IsInline() || tag.Delimiter() != ";" {
+		t.Fatalf("invalid inline tag: got %+v", tag)
+	}
+	if tag, _ := ParseTag("x,explode"); tag.Delimiter() != "" {
+		t.Fatalf("invalid delimiter for explode: got %q", tag.Delimiter())
+	}
+
+	for value, message := range map[string]string{
+		"x,unknown":             `invalid tag "x,unknown": unknown option "unknown"`,
+		"x,required=yes":        `invalid tag "x,required=yes": option "required" does not take a value`,
+		"x,format":              `invalid tag "x,format": option "format" requires a value (e.g. format=...)`,
+		"x,format=":             `invalid tag "x,format=": empty value for option "format"`,
+		"x,default='abc":        `invalid tag "x,default='abc": unterminated quoted value for option "default"`,
+		"x,default='a'b":        `invalid tag "x,default='a'b": unexpected characters after quoted value for option "default"`,
+		"x,omitempty,omitempty": `invalid tag "x,omitempty,omitempty": duplicate option "omitempty"`,
+		"x,comma,pipe":          `invalid tag "x,comma,pipe": only one of options "comma", "space", "pipe" and "delimiter" can be given`,
+		"x,style=matrix":        `invalid tag "x,style=matrix": unsupported style "matrix": must be one of "bracket", "dot", "deepObject" and "form"`,
+		"x,explode=no":          `invalid tag "x,explode=no": invalid value "no" for option "explode": must be "true" or "false"`,
+		"x,=value":              `invalid tag "x,=value": missing option name before "=value"`,
+	} {
+		_, err := ParseTag(value)
+		var tagErr *TagError
+		if !errors.As(err, &tagErr) {
+			t.Fatalf("expected tag error for %q, got %v", value, err)
+		}
+		if err.Error() != message {
+			t.Fatalf("invalid error message for %q:\nexpected %q\ngot      %q", value, message, err.Error())
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dihedron/go-log"
+)
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// UnmarshalHeaders populates the fields of the target struct, which must be
+// passed by pointer, from the given set of HTTP headers; this is the reverse
+// of HeadersFrom() and uses the same "header" tag, so the same struct can be
+// used in both directions; malformed tags are reported as a *TagError, and an
+// empty name in the tag means the name of the field. Its behaviour is the
+// following:
+//   - untagged (or "inline") embedded structs, child structs and pointers to
+//     structs are populated recursively
+//   - fields tagged with "-" are left untouched, and so are fields whose header
+//     is missing, unless their tag gives a default value, which is converted as
+//     if it were the value of the header
+//   - strings, booleans, integers (in the base selected by the "format" option,
+//     if any), floating point numbers, durations (either as Go durations or as
+//     a number of seconds), time.Time values (according to the "format" option
+//     or, if there is none, in any of the formats allowed by HTTP, or RFC3339)
+//     and types implementing the encoding.TextUnmarshaler interface are
+//     converted from the first value; fmt verbs in the "format" option are not
+//     used for parsing
+//   - slices are populated with all the values, after splitting them with the
+//     delimiter selected by the "delimiter", "comma", "space" or "pipe" options
+//     (or by "explode=false") or, if there is none, as comma-separated lists.
+func UnmarshalHeaders(header http.Header, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("only pointers to structs can be passed as targets, not %T", target)
+	}
+	_, err := unmarshalHeaders(header, value.Elem())
+	return err
+}
+
+// Headers populates the fields of the target struct from the response headers;
+// see UnmarshalHeaders() for details.
+func (r *Response) Headers(target interface{}) error {
+	return UnmarshalHeaders(r.Header, target)
+}
+
+// unmarshalHeaders populates the given struct value and returns whether any of
+// its fields was set.
+func unmarshalHeaders(header http.Header, target reflect.Value) (bool, error) {
+	found := false
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		value := target.Field(i)
+		var tag Tag
+		if value.CanSet() {
+			var err error
+			if tag, err = ParseTag(field.Tag.Get("header")); err != nil {
+				tagErr := err.(*TagError)
+				tagErr.Field = field.Name
+				return false, tagErr
+			}
+		} else if !field.Anonymous || field.Type.Kind() != reflect.Struct {
+			// unexported field
+			continue
+		}
+		// otherwise the field embeds a struct of an unexported type, whose
+		// exported fields are populated as if untagged, as HeadersFrom() reads
+		// them
+		if tag.IsIgnore() {
+			continue
+		}
+		if tag.IsMissing() || tag.IsInline() {
+			if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+				log.Debugf("... field %q is a struct, recursing...", field.Name)
+				ok, err := unmarshalHeaders(header, value)
+				if err != nil {
+					return false, err
+				}
+				found = found || ok
+			} else if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct && field.Type.Elem() != timeType {
+				log.Debugf("... field %q is a struct pointer, recursing...", field.Name)
+				child := value
+				if value.IsNil() {
+					child = reflect.New(field.Type.Elem())
+				}
+				ok, err := unmarshalHeaders(header, child.Elem())
+				if err != nil {
+					return false, err
+				}
+				if ok && value.IsNil() {
+					value.Set(child)
+				}
+				found = found || ok
+			}
+			continue
+		}
+		name := tag.Name()
+		if name == "" {
+			name = field.Name
+		}
+		values := header.Values(name)
+		if len(values) == 0 {
+			d, ok := tag.Default()
+			if !ok {
+				log.Debugf("... no header %q for field %q", name, field.Name)
+				continue
+			}
+			values = []string{d}
+		}
+		if err := setFromStrings(value, values, tag); err != nil {
+			return false, fmt.Errorf("error setting field %q from header %q: %v", field.Name, name, err)
+		}
+		found = true
+	}
+	return found, nil
+}
+
+// setFromStrings sets the given value from a set of strings, according to the
+// options in the tag; slices receive all values, all other types only the first
+// one.
+func setFromStrings(value reflect.Value, values []string, tag Tag) error {
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() != reflect.Uint8 && !reflect.PtrTo(value.Type()).Implements(textUnmarshalerType) {
+		delimiter := tag.Delimiter()
+		var tokens []string
+		for _, v := range values {
+			if delimiter == "" && value.Type().Elem() == timeType {
+				// HTTP dates contain commas
+				tokens = append(tokens, strings.TrimSpace(v))
+				continue
+			}
+			if delimiter == "" {
+				delimiter = ","
+			}
+			for _, token := range strings.Split(v, delimiter) {
+				if token = strings.TrimSpace(token); token != "" {
+					tokens = append(tokens, token)
+				}
+			}
+		}
+		slice := reflect.MakeSlice(value.Type(), len(tokens), len(tokens))
+		for i, token := range tokens {
+			if err := setFromString(slice.Index(i), token, tag); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+		return nil
+	}
+	return setFromString(value, strings.TrimSpace(values[0]), tag)
+}
+
+// setFromString converts the given string to the type of the value, according
+// to the "format" option in the tag, and sets it.
+func setFromString(value reflect.Value, s string, tag Tag) error {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return setFromString(value.Elem(), s, tag)
+	}
+
+	if value.CanAddr() && value.Addr().Type().Implements(textUnmarshalerType) && value.Type() != timeType {
+		return value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch value.Type() {
+	case timeType:
+		t, err := parseTime(s, tag)
+		if err != nil {
+			return err
+		}
+		value.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+			value.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	base := 10
+	if format, _ := tag.Option("format"); format != "" && !strings.HasPrefix(format, "%") {
+		switch value.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var err error
+			if base, err = integerBase(format); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported format %q for values of type %s", format, value.Type())
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, base, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, base, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %v", value.Type())
+		}
+		value.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("unsupported type %v", value.Type())
+	}
+	return nil
+}
+
+// parseTime parses a time.Time value according to the "format" option in the
+// tag, as the reverse of formatTime(); if there is none, any of the formats
+// allowed by HTTP, or RFC3339, is accepted.
+func parseTime(s string, tag Tag) (time.Time, error) {
+	format, _ := tag.Option("format")
+	switch format {
+	case "":
+		if t, err := http.ParseTime(s); err == nil {
+			return t, nil
+		}
+		return time.Parse(time.RFC3339, s)
+	case "unix", "unixmilli":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if format == "unixmilli" {
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	if layout, ok := timeFormats[format]; ok {
+		format = layout
+	}
+	return time.Parse(format, s)
+}
@@ -0,0 +1,233 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalHeaders(t *testing.T) {
+
+	type RateLimit struct {
+		Limit     int       `header:"X-RateLimit-Limit"`
+		Remaining *int      `header:"X-RateLimit-Remaining"`
+		Reset     time.Time `header:"X-RateLimit-Reset"`
+	}
+
+	type Paging struct {
+		Total uint64 `header:"X-Total-Count"`
+	}
+
+	type Unused struct {
+		Value string `header:"X-Unused"`
+	}
+
+	type Struct struct {
+		ETag       string        `header:"ETag"`
+		Location   *string       `header:"Location"`
+		Allow      []string      `header:"Allow"`
+		Cached     bool          `header:"X-Cached"`
+		Ratio      float64       `header:"X-Ratio"`
+		RetryAfter time.Duration `header:"Retry-After"`
+		Date       *time.Time    `header:"Date"`
+		Address    net.IP        `header:"X-Address"`
+		Missing    string        `header:"X-Missing"`
+		Ignored    string        `header:"-"`
+		RateLimit
+		Paging *Paging
+		Empty  *Unused
+	}
+
+	header := http.Header{}
+	header.Set("ETag", `"abcdef"`)
+	header.Set("Location", "https://www.example.com/resource/1")
+	header.Add("Allow", "GET, HEAD")
+	header.Add("Allow", "POST")
+	header.Set("X-Cached", "true")
+	header.Set("X-Ratio", "0.75")
+	header.Set("Retry-After", "120")
+	header.Set("Date", "Sun, 11 Mar 2018 22:11:16 GMT")
+	header.Set("X-Address", "192.168.1.1")
+	header.Set("X-RateLimit-Limit", "5000")
+	header.Set("X-RateLimit-Remaining", "4999")
+	header.Set("X-RateLimit-Reset", "2018-03-11T22:11:16Z")
+	header.Set("X-Total-Count", "42")
+
+	s := Struct{Missing: "untouched", Ignored: "untouched"}
+	if err := UnmarshalHeaders(header, &s); err != nil {
+		t.Fatalf("error unmarshalling headers: %v", err)
+	}
+
+	if s.ETag != `"abcdef"` {
+		t.Fatalf("invalid ETag: got %q", s.ETag)
+	}
+	if s.Location == nil || *s.Location != "https://www.example.com/resource/1" {
+		t.Fatalf("invalid Location: got %v", s.Location)
+	}
+	if len(s.Allow) != 3 || s.Allow[0] != "GET" || s.Allow[1] != "HEAD" || s.Allow[2] != "POST" {
+		t.Fatalf("invalid Allow: got %q", s.Allow)
+	}
+	if !s.Cached {
+		t.Fatalf("invalid X-Cached: expected true")
+	}
+	if s.Ratio != 0.75 {
+		t.Fatalf("invalid X-Ratio: expected 0.75, got %v", s.Ratio)
+	}
+	if s.RetryAfter != 2*time.Minute {
+		t.Fatalf("invalid Retry-After: expected 2m, got %v", s.RetryAfter)
+	}
+	expected := time.Date(2018, time.March, 11, 22, 11, 16, 0, time.UTC)
+	if s.Date == nil || !s.Date.Equal(expected) {
+		t.Fatalf("invalid Date: got %v", s.Date)
+	}
+	if !s.Address.Equal(net.ParseIP("192.168.1.1")) {
+		t.Fatalf("invalid X-Address: got %v", s.Address)
+	}
+	if s.Missing != "untouched" || s.Ignored != "untouched" {
+		t.Fatalf("missing and ignored fields should be left untouched")
+	}
+	if s.Limit != 5000 || s.Remaining == nil || *s.Remaining != 4999 || !s.Reset.Equal(expected) {
+		t.Fatalf("invalid rate limit: got %+v", s.RateLimit)
+	}
+	if s.Paging == nil || s.Paging.Total != 42 {
+		t.Fatalf("invalid paging: got %+v", s.Paging)
+	}
+	if s.Empty != nil {
+		t.Fatalf("struct pointers with no matching headers should be left nil")
+	}
+}
+
+func TestUnmarshalHeadersOptions(t *testing.T) {
+	type Struct struct {
+		Reset   time.Time   `header:"X-Reset,format=unix"`
+		Day     time.Time   `header:"X-Day,format=date"`
+		Stamps  []time.Time `header:"X-Stamps,format=unixmilli,comma"`
+		Tags    []string    `header:"X-Tags,delimiter=';'"`
+		Scopes  []string    `header:"X-Scopes,space"`
+		Color   int         `header:"X-Color,format=hex"`
+		Mask    *uint8      `header:"X-Mask,format=binary"`
+		Price   float64     `header:"X-Price,format=%.2f"`
+		Retries int         `header:"X-Retries,default=3"`
+	}
+
+	mask := uint8(5)
+	source := Struct{
+		Reset:  time.Unix(1600000000, 0),
+		Day:    time.Date(2021, time.April, 20, 0, 0, 0, 0, time.UTC),
+		Stamps: []time.Time{time.Unix(0, 1500*int64(time.Millisecond)), time.Unix(2, 0)},
+		Tags:   []string{"a,b", "c"},
+		Scopes: []string{"read", "write"},
+		Color:  255,
+		Mask:   &mask,
+		Price:  2.5,
+	}
+	req, err := New("https://www.example.com/").HeadersFrom(source).Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+
+	var target Struct
+	if err := UnmarshalHeaders(req.Header, &target); err != nil {
+		t.Fatalf("error unmarshalling headers %v: %v", req.Header, err)
+	}
+	if !target.Reset.Equal(source.Reset) || !target.Day.Equal(source.Day) {
+		t.Fatalf("invalid times: expected %v and %v, got %v and %v", source.Reset, source.Day, target.Reset, target.Day)
+	}
+	if len(target.Stamps) != 2 || !target.Stamps[0].Equal(source.Stamps[0]) || !target.Stamps[1].Equal(source.Stamps[1]) {
+		t.Fatalf("invalid X-Stamps: expected %v, got %v", source.Stamps, target.Stamps)
+	}
+	if len(target.Tags) != 2 || target.Tags[0] != "a,b" || target.Tags[1] != "c" {
+		t.Fatalf("invalid X-Tags: expected %q, got %q", source.Tags, target.Tags)
+	}
+	if len(target.Scopes) != 2 || target.Scopes[0] != "read" || target.Scopes[1] != "write" {
+		t.Fatalf("invalid X-Scopes: expected %q, got %q", source.Scopes, target.Scopes)
+	}
+	if target.Color != 255 || target.Mask == nil || *target.Mask != 5 || target.Price != 2.5 {
+		t.Fatalf("invalid numbers: got %d, %v and %v", target.Color, target.Mask, target.Price)
+	}
+	if target.Retries != 3 {
+		t.Fatalf("invalid X-Retries: expected default 3, got %d", target.Retries)
+	}
+
+	// defaults apply to missing headers, as if they were their values
+	target = Struct{}
+	if err := UnmarshalHeaders(http.Header{}, &target); err != nil {
+		t.Fatalf("error unmarshalling headers: %v", err)
+	}
+	if target.Retries != 3 || !target.Reset.IsZero() {
+		t.Fatalf("invalid defaults: got %+v", target)
+	}
+
+	// formats are checked as when marshalling
+	header := http.Header{}
+	header.Set("X-Name", "x")
+	if err := UnmarshalHeaders(header, &struct {
+		Name string `header:"X-Name,format=hex"`
+	}{}); err == nil {
+		t.Fatalf("expected error on unsupported format")
+	}
+}
+
+func TestUnmarshalHeadersTags(t *testing.T) {
+	type Inner struct {
+		Value string `header:"X-Inner"`
+	}
+	type hidden struct {
+		Value int `header:"X-Hidden"`
+	}
+	type Struct struct {
+		ETag   string `header:",omitempty"`
+		Inner  Inner  `header:",inline"`
+		Nested *Inner `header:",inline"`
+		hidden
+	}
+
+	source := Struct{ETag: `"abc"`, Inner: Inner{Value: "inner"}, hidden: hidden{Value: 42}}
+	req, err := New("https://www.example.com/").HeadersFrom(source).Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.Header.Get("Etag") != `"abc"` || req.Header.Get("X-Inner") != "inner" || req.Header.Get("X-Hidden") != "42" {
+		t.Fatalf("invalid headers: got %v", req.Header)
+	}
+
+	var target Struct
+	if err := UnmarshalHeaders(req.Header, &target); err != nil {
+		t.Fatalf("error unmarshalling headers: %v", err)
+	}
+	if target.ETag != source.ETag || target.Inner != source.Inner || target.hidden != source.hidden {
+		t.Fatalf("invalid round trip: expected %+v, got %+v", source, target)
+	}
+	if target.Nested == nil || target.Nested.Value != "inner" {
+		t.Fatalf("invalid inline pointer: got %+v", target.Nested)
+	}
+
+	// malformed tags are reported as in HeadersFrom()
+	var tagErr *TagError
+	if err := UnmarshalHeaders(req.Header, &struct {
+		Name string `header:"X-Name,requird"`
+	}{}); !errors.As(err, &tagErr) || tagErr.Field != "Name" {
+		t.Fatalf("expected *TagError for field Name, got %v", err)
+	}
+}
+
+func TestUnmarshalHeadersErrors(t *testing.T) {
+	type Struct struct {
+		Limit int `header:"X-RateLimit-Limit"`
+	}
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "not a number")
+	if err := UnmarshalHeaders(header, &Struct{}); err == nil {
+		t.Fatalf("expected error on invalid integer value, got none")
+	}
+	if err := UnmarshalHeaders(header, Struct{}); err == nil {
+		t.Fatalf("expected error on non-pointer target, got none")
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// templateOperator describes how the expressions introduced by an RFC 6570
+// operator are expanded.
+type templateOperator struct {
+	first    string
+	sep      string
+	named    bool
+	ifempty  string
+	reserved bool
+}
+
+// templateOperators maps the RFC 6570 operators to their expansion rules, as
+// per appendix A of the RFC.
+var templateOperators = map[byte]templateOperator{
+	0:   {first: "", sep: ","},
+	'+': {first: "", sep: ",", reserved: true},
+	'#': {first: "#", sep: ",", reserved: true},
+	'.': {first: ".", sep: "."},
+	'/': {first: "/", sep: "/"},
+	';': {first: ";", sep: ";", named: true},
+	'?': {first: "?", sep: "&", named: true, ifempty: "="},
+	'&': {first: "&", sep: "&", named: true, ifempty: "="},
+}
+
+// templateValue normalises a variable value into one of the three kinds of
+// values supported by URI templates: strings, lists ([]string) and associative
+// arrays (map[string]string); nil values are undefined, and are returned as
+// nil.
+func templateValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch value := value.(type) {
+	case string:
+		return value
+	case []string:
+		return append([]string(nil), value...)
+	case map[string]string:
+		m := make(map[string]string, len(value))
+		for k, v := range value {
+			m[k] = v
+		}
+		return m
+	case fmt.Stringer:
+		return value.String()
+	case []byte:
+		return string(value)
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return templateValue(v.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		list := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			list = append(list, fmt.Sprintf("%v", v.Index(i).Interface()))
+		}
+		return list
+	case reflect.Map:
+		m := make(map[string]string, v.Len())
+		for _, key := range v.MapKeys() {
+			m[fmt.Sprintf("%v", key.Interface())] = fmt.Sprintf("%v", v.MapIndex(key).Interface())
+		}
+		return m
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// expandTemplate expands the given URI template (RFC 6570, up to level 4) with
+// the given variables, whose values must have been normalised by
+// templateValue(); undefined variables, empty lists and empty maps expand to
+// nothing, and the keys of maps are expanded in sorted order.
+func expandTemplate(template string, variables map[string]interface{}) (string, error) {
+	var buffer strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			buffer.WriteString(template)
+			return buffer.String(), nil
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated expression in URI template at offset %d", start)
+		}
+		buffer.WriteString(template[:start])
+		if err := expandExpression(&buffer, template[start+1:start+end], variables); err != nil {
+			return "", err
+		}
+		template = template[start+end+1:]
+	}
+}
+
+// templateNames returns the names of the variables referenced by the given URI
+// template, in order of appearance and without duplicates.
+func templateNames(template string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			return names, nil
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated expression in URI template at offset %d", start)
+		}
+		expression := template[start+1 : start+end]
+		if expression != "" {
+			if _, ok := templateOperators[expression[0]]; ok {
+				expression = expression[1:]
+			}
+		}
+		for _, spec := range strings.Split(expression, ",") {
+			name, _, _, err := parseVarspec(spec)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		template = template[start+end+1:]
+	}
+}
+
+// expandExpression expands a single template expression, without the braces,
+// into the buffer; since values are escaped so that they cannot contain
+// slashes, the only way for them to alter the path would be to expand to dot
+// segments ("." and ".."), which servers resolve even if percent-encoded, so
+// simple and path segment expansions in the path (i.e. before any "?" or "#")
+// that have them are rejected.
+func expandExpression(buffer *strings.Builder, expression string, variables map[string]interface{}) error {
+	if expression == "" {
+		return errors.New("empty expression in URI template")
+	}
+	operator, ok := templateOperators[expression[0]]
+	if ok {
+		expression = expression[1:]
+	} else if strings.ContainsRune("=,!@|", rune(expression[0])) {
+		return fmt.Errorf("unsupported operator %q in URI template", expression[0])
+	} else {
+		operator = templateOperators[0]
+	}
+
+	var expansion strings.Builder
+	if err := expandVarspecs(&expansion, operator, expression, variables); err != nil {
+		return err
+	}
+	result := expansion.String()
+	inPath := !strings.ContainsAny(buffer.String(), "?#")
+	if inPath && !operator.reserved && (operator.first == "" || operator.first == "/") && hasDotSegment(result) {
+		return fmt.Errorf("expression {%s}: %w", expression, ErrDotSegment)
+	}
+	buffer.WriteString(result)
+	return nil
+}
+
+// hasDotSegment returns whether the expansion has a dot segment once decoded,
+// as many times as it can be, so that servers decoding paths more than once
+// are protected too.
+func hasDotSegment(expansion string) bool {
+	for {
+		for _, segment := range strings.Split(expansion, "/") {
+			if segment == "." || segment == ".." {
+				return true
+			}
+		}
+		decoded, err := url.PathUnescape(expansion)
+		if err != nil || decoded == expansion {
+			return false
+		}
+		expansion = decoded
+	}
+}
+
+// expandVarspecs expands the comma-separated list of variable specifications of
+// an expression into the buffer, according to the rules of the operator.
+func expandVarspecs(buffer *strings.Builder, operator templateOperator, expression string, variables map[string]interface{}) error {
+
+	first := true
+	for _, spec := range strings.Split(expression, ",") {
+		name, explode, prefix, err := parseVarspec(spec)
+		if err != nil {
+			return err
+		}
+		value := variables[name]
+		switch v := value.(type) {
+		case nil:
+			continue
+		case []string:
+			if len(v) == 0 {
+				continue
+			}
+		case map[string]string:
+			if len(v) == 0 {
+				continue
+			}
+		}
+		if first {
+			buffer.WriteString(operator.first)
+			first = false
+		} else {
+			buffer.WriteString(operator.sep)
+		}
+
+		switch v := value.(type) {
+		case string:
+			if prefix > 0 && utf8.RuneCountInString(v) > prefix {
+				v = string([]rune(v)[:prefix])
+			}
+			writeTemplateValue(buffer, operator, name, v)
+		case []string:
+			if prefix > 0 {
+				return fmt.Errorf("prefix modifier not applicable to list variable %q", name)
+			}
+			if explode {
+				for i, item := range v {
+					if i > 0 {
+						buffer.WriteString(operator.sep)
+					}
+					writeTemplateValue(buffer, operator, name, item)
+				}
+			} else {
+				items := make([]string, 0, len(v))
+				for _, item := range v {
+					items = append(items, encodeTemplateValue(item, operator.reserved))
+				}
+				if operator.named {
+					buffer.WriteString(name + "=")
+				}
+				buffer.WriteString(strings.Join(items, ","))
+			}
+		case map[string]string:
+			if prefix > 0 {
+				return fmt.Errorf("prefix modifier not applicable to associative array variable %q", name)
+			}
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if explode {
+				for i, key := range keys {
+					if i > 0 {
+						buffer.WriteString(operator.sep)
+					}
+					if operator.named {
+						writeTemplateValue(buffer, operator, encodeTemplateValue(key, operator.reserved), v[key])
+					} else {
+						buffer.WriteString(encodeTemplateValue(key, operator.reserved) + "=" + encodeTemplateValue(v[key], operator.reserved))
+					}
+				}
+			} else {
+				items := make([]string, 0, 2*len(keys))
+				for _, key := range keys {
+					items = append(items, encodeTemplateValue(key, operator.reserved), encodeTemplateValue(v[key], operator.reserved))
+				}
+				if operator.named {
+					buffer.WriteString(name + "=")
+				}
+				buffer.WriteString(strings.Join(items, ","))
+			}
+		}
+	}
+	return nil
+}
+
+// writeTemplateValue writes a single string value, preceded by its name if the
+// operator is a named one.
+func writeTemplateValue(buffer *strings.Builder, operator templateOperator, name, value string) {
+	if operator.named {
+		buffer.WriteString(name)
+		if value == "" {
+			buffer.WriteString(operator.ifempty)
+			return
+		}
+		buffer.WriteString("=")
+	}
+	buffer.WriteString(encodeTemplateValue(value, operator.reserved))
+}
+
+// parseVarspec parses a variable specification, returning the variable name,
+// whether it has the explode modifier and the length of its prefix modifier,
+// if any.
+func parseVarspec(spec string) (name string, explode bool, prefix int, err error) {
+	name = spec
+	if strings.HasSuffix(spec, "*") {
+		name, explode = spec[:len(spec)-1], true
+	} else if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name = spec[:i]
+		prefix, err = strconv.Atoi(spec[i+1:])
+		if err != nil || prefix <= 0 || prefix >= 10000 || len(spec[i+1:]) > 4 {
+			return "", false, 0, fmt.Errorf("invalid prefix modifier in URI template variable %q", spec)
+		}
+	}
+	if !isVarname(name) {
+		return "", false, 0, fmt.Errorf("invalid URI template variable name %q", name)
+	}
+	return name, explode, prefix, nil
+}
+
+// isVarname returns whether the name is a valid variable name, made of
+// letters, digits, underscores and percent-encoded triplets, possibly
+// separated by single dots.
+func isVarname(name string) bool {
+	if name == "" || name[0] == '.' || name[len(name)-1] == '.' || strings.Contains(name, "..") {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '_', c == '.':
+		case c == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// encodeTemplateValue percent-encodes all characters but the unreserved ones
+// and, if reserved expansion is allowed, the reserved ones and existing
+// percent-encoded triplets.
+func encodeTemplateValue(value string, reserved bool) string {
+	var buffer strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			buffer.WriteByte(c)
+		case reserved && strings.IndexByte(":/?#[]@!$&'()*+,;=", c) >= 0:
+			buffer.WriteByte(c)
+		case reserved && c == '%' && i+2 < len(value) && isHex(value[i+1]) && isHex(value[i+2]):
+			buffer.WriteString(value[i : i+3])
+			i += 2
+		default:
+			fmt.Fprintf(&buffer, "%%%02X", c)
+		}
+	}
+	return buffer.String()
+}
+
+// isHex returns whether the character is a hexadecimal digit.
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,264 @@
+// Copyright 2017-present Andrea Funtò. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package request
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestExpandTemplate uses the examples from RFC 6570, section 3.2; since the
+// keys of associative arrays are sorted, the expected expansions of "keys" are
+// reordered accordingly.
+func TestExpandTemplate(t *testing.T) {
+	variables := map[string]interface{}{}
+	for key, value := range map[string]interface{}{
+		"count":      []string{"one", "two", "three"},
+		"dom":        []string{"example", "com"},
+		"dub":        "me/too",
+		"hello":      "Hello World!",
+		"half":       "50%",
+		"var":        "value",
+		"who":        "fred",
+		"base":       "http://example.com/home/",
+		"path":       "/foo/bar",
+		"list":       []string{"red", "green", "blue"},
+		"keys":       map[string]string{"semi": ";", "dot": ".", "comma": ","},
+		"v":          "6",
+		"x":          "1024",
+		"y":          "768",
+		"empty":      "",
+		"empty_keys": map[string]string{},
+		"undef":      nil,
+	} {
+		if value := templateValue(value); value != nil {
+			variables[key] = value
+		}
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		// level 1
+		{"{var}", "value"},
+		{"{hello}", "Hello%20World%21"},
+		// level 2
+		{"{+var}", "value"},
+		{"{+hello}", "Hello%20World!"},
+		{"{+path}/here", "/foo/bar/here"},
+		{"here?ref={+path}", "here?ref=/foo/bar"},
+		{"X{#var}", "X#value"},
+		{"X{#hello}", "X#Hello%20World!"},
+		{"{+half}", "50%25"},
+		// level 3
+		{"map?{x,y}", "map?1024,768"},
+		{"{x,hello,y}", "1024,Hello%20World%21,768"},
+		{"{+x,hello,y}", "1024,Hello%20World!,768"},
+		{"{+path,x}/here", "/foo/bar,1024/here"},
+		{"{#x,hello,y}", "#1024,Hello%20World!,768"},
+		{"{#path,x}/here", "#/foo/bar,1024/here"},
+		{"X{.var}", "X.value"},
+		{"X{.x,y}", "X.1024.768"},
+		{"{/var}", "/value"},
+		{"{/var,x}/here", "/value/1024/here"},
+		{"{;x,y}", ";x=1024;y=768"},
+		{"{;x,y,empty}", ";x=1024;y=768;empty"},
+		{"{?x,y}", "?x=1024&y=768"},
+		{"{?x,y,empty}", "?x=1024&y=768&empty="},
+		{"?fixed=yes{&x}", "?fixed=yes&x=1024"},
+		{"{&x,y,empty}", "&x=1024&y=768&empty="},
+		// level 4
+		{"{var:3}", "val"},
+		{"{var:30}", "value"},
+		{"{list}", "red,green,blue"},
+		{"{list*}", "red,green,blue"},
+		{"{keys}", "comma,%2C,dot,.,semi,%3B"},
+		{"{keys*}", "comma=%2C,dot=.,semi=%3B"},
+		{"{+path:6}/here", "/foo/b/here"},
+		{"{+list}", "red,green,blue"},
+		{"{+keys*}", "comma=,,dot=.,semi=;"},
+		{"{#list*}", "#red,green,blue"},
+		{"{#keys}", "#comma,,,dot,.,semi,;"},
+		{"X{.list*}", "X.red.green.blue"},
+		{"X{.keys*}", "X.comma=%2C.dot=..semi=%3B"},
+		{"{/var:1,var}", "/v/value"},
+		{"{/list*}", "/red/green/blue"},
+		{"{/list*,path:4}", "/red/green/blue/%2Ffoo"},
+		{"{/keys*}", "/comma=%2C/dot=./semi=%3B"},
+		{"{;hello:5}", ";hello=Hello"},
+		{"{;list}", ";list=red,green,blue"},
+		{"{;list*}", ";list=red;list=green;list=blue"},
+		{"{;keys*}", ";comma=%2C;dot=.;semi=%3B"},
+		{"{?var:3}", "?var=val"},
+		{"{?list}", "?list=red,green,blue"},
+		{"{?list*}", "?list=red&list=green&list=blue"},
+		{"{?keys}", "?keys=comma,%2C,dot,.,semi,%3B"},
+		{"{?keys*}", "?comma=%2C&dot=.&semi=%3B"},
+		{"{&list*}", "&list=red&list=green&list=blue"},
+		// undefined values
+		{"{undef}{?undef,empty_keys*}{/undef}", ""},
+		{"{count}/{dom*}", "one,two,three/example,com"},
+		{"{/count*}{.dom*}", "/one/two/three.example.com"},
+	}
+	for _, test := range tests {
+		actual, err := expandTemplate(test.template, variables)
+		if err != nil {
+			t.Fatalf("error expanding %q: %v", test.template, err)
+		}
+		if actual != test.expected {
+			t.Fatalf("error expanding %q: expected %q, got %q", test.template, test.expected, actual)
+		}
+	}
+
+	for _, template := range []string{"{var", "{}", "{!var}", "{var:0}", "{var:10000}", "{va r}", "{list:3}", "{keys:1}", "{.}"} {
+		if _, err := expandTemplate(template, variables); err == nil {
+			t.Fatalf("expected error expanding %q, got none", template)
+		}
+	}
+}
+
+func TestTemplateVariables(t *testing.T) {
+	type color int
+	req, err := New("https://example.com/{+base}{/segments*}{?filter*,ids,page}").
+		Variable("base", "api/v1").
+		Variable("segments", []interface{}{"users", 42}).
+		Variable("filter", map[string]color{"red": 1, "blue": 2}).
+		Variable("ids", [2]int{3, 4}).
+		Variable("page", nil).
+		Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if expected := "https://example.com/api/v1/users/42?blue=2&red=1&ids=3,4"; req.URL.String() != expected {
+		t.Fatalf("invalid URL: expected %q, got %q", expected, req.URL.String())
+	}
+
+	req, err = New("https://example.com/").Path("search{?q}").Set().Variable("q", "go lang").Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if expected := "https://example.com/search?q=go%20lang"; req.URL.String() != expected {
+		t.Fatalf("invalid URL: expected %q, got %q", expected, req.URL.String())
+	}
+
+	var urlErr *URLError
+	if _, err := New("https://example.com/{id").Make(); !errors.As(err, &urlErr) {
+		t.Fatalf("expected URL error on invalid template, got %v", err)
+	}
+}
+
+func TestTemplateEscaping(t *testing.T) {
+	tests := []struct {
+		template string
+		value    string
+		expected string
+		path     string
+	}{
+		{"https://example.com/users/{id}", "a..b", "https://example.com/users/a..b", "/users/a..b"},
+		{"https://example.com/users{/id}", "a b?c#d", "https://example.com/users/a%20b%3Fc%23d", "/users/a b?c#d"},
+		{"https://example.com/files/a%2Fb/{name}", "c/d", "https://example.com/files/a%2Fb/c%2Fd", "/files/a/b/c/d"},
+		{"https://example.com/files/{+name}", "c/d", "https://example.com/files/c/d", "/files/c/d"},
+		{"https://example.com/files/{+name}", "../d", "https://example.com/files/../d", "/files/../d"},
+	}
+	for _, test := range tests {
+		req, err := New(test.template).Set().Variable("id", test.value).Variable("name", test.value).Make()
+		if err != nil {
+			t.Fatalf("error making request: %v", err)
+		}
+		if req.URL.String() != test.expected {
+			t.Fatalf("invalid URL for %q: expected %q, got %q", test.value, test.expected, req.URL.String())
+		}
+		if req.URL.Path != test.path {
+			t.Fatalf("invalid path for %q: expected %q, got %q", test.value, test.path, req.URL.Path)
+		}
+	}
+
+	// dot segments, even if escaped, are rejected in simple and path segment
+	// expansions in the path, but not elsewhere
+	for _, test := range []struct {
+		template string
+		value    interface{}
+	}{
+		{"https://example.com/users/{id}", "../admin"},
+		{"https://example.com/users/{id}", ".."},
+		{"https://example.com/users/{id}/posts", "."},
+		{"https://example.com/users/{id}", "a/./b"},
+		{"https://example.com/users/{id}", "%2E%2E"},
+		{"https://example.com/users/{id}", ".%2E%2Fadmin"},
+		{"https://example.com/users/{id}", "%252e%252e%252fadmin"},
+		{"https://example.com/users/{id:2}", "..x"},
+		{"https://example.com/users{/id}", ".."},
+		{"https://example.com/users{/id*}", []string{"a", ".."}},
+		{"https://example.com/users{/id*}", []string{"."}},
+	} {
+		_, err := New(test.template).Set().Variable("id", test.value).Make()
+		var urlErr *URLError
+		if !errors.As(err, &urlErr) || !errors.Is(err, ErrDotSegment) {
+			t.Fatalf("expected *URLError wrapping ErrDotSegment for %q in %q, got %v", test.value, test.template, err)
+		}
+	}
+	variables := map[string]interface{}{"list": []string{"a", "..", "."}}
+	for template, expected := range map[string]string{
+		"{?list}":   "?list=a,..,.",
+		"{.list}":   ".a,..,.",
+		"{+list}":   "a,..,.",
+		"{#list}":   "#a,..,.",
+		"{list}":    "a,..,.",
+		"?q={list}": "?q=a,..,.",
+		"#{/list*}": "#/a/../.",
+	} {
+		actual, err := expandTemplate(template, variables)
+		if err != nil {
+			t.Fatalf("error expanding %q: %v", template, err)
+		}
+		if actual != expected {
+			t.Fatalf("error expanding %q: expected %q, got %q", template, expected, actual)
+		}
+	}
+
+	// encoded slashes survive path resolution
+	req, err := New("https://example.com/repos/").Path("a%2Fb/{file}").Set().Variable("file", "x/y").Make()
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	if req.URL.EscapedPath() != "/repos/a%2Fb/x%2Fy" || req.URL.RawPath != "/repos/a%2Fb/x%2Fy" {
+		t.Fatalf("invalid escaped path: got %q (raw %q)", req.URL.EscapedPath(), req.URL.RawPath)
+	}
+}
+
+// TestTemplateTraversal checks that variables cannot reach other resources on
+// a server that resolves dot segments, escaped or not, as http.ServeMux does.
+func TestTemplateTraversal(t *testing.T) {
+	mux := http.NewServeMux()
+	admin := false
+	mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		admin = true
+	})
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, value := range []string{"../admin", "..%2Fadmin", "%2E%2E/admin", "%2e%2e%2fadmin"} {
+		_, err := New(server.URL+"/users/{id}").Set().Variable("id", value).Do(context.Background())
+		var urlErr *URLError
+		if !errors.As(err, &urlErr) || !errors.Is(err, ErrDotSegment) {
+			t.Fatalf("expected *URLError wrapping ErrDotSegment for %q, got %v", value, err)
+		}
+	}
+	if admin {
+		t.Fatalf("the admin resource was reached")
+	}
+
+	res, err := New(server.URL+"/users/{id}").Set().Variable("id", "a..b/c").Do(context.Background())
+	if err != nil || res.String() != "/users/a..b/c" {
+		t.Fatalf("expected the user resource, got %v (error: %v)", res, err)
+	}
+}